@@ -3,6 +3,8 @@ module github.com/green-ecolution/tbz-csv-import-plugin
 go 1.23.4
 
 require (
+	github.com/coreos/go-oidc/v3 v3.11.0
+	github.com/go-jose/go-jose/v4 v4.0.2
 	github.com/gofiber/fiber/v2 v2.52.5
 	github.com/green-ecolution/green-ecolution-backend/client v0.0.0-00010101000000-000000000000
 	github.com/green-ecolution/green-ecolution-backend/plugin v0.0.0-00010101000000-000000000000
@@ -11,7 +13,9 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/omniscale/go-proj/v2 v2.0.0-20221006090944-6c8a5f5a510d
 	github.com/pkg/errors v0.9.1
+	github.com/pressly/goose/v3 v3.23.1
 	golang.org/x/oauth2 v0.24.0
+	golang.org/x/text v0.21.0
 )
 
 require (
@@ -23,14 +27,13 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/mfridman/interpolate v0.0.2 // indirect
-	github.com/pressly/goose v2.7.0+incompatible // indirect
-	github.com/pressly/goose/v3 v3.23.1 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/sethvargo/go-retry v0.3.0 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasthttp v1.58.0 // indirect
 	github.com/valyala/tcplisten v1.0.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
+	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 )
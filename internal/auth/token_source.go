@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// expiryMargin is how long before its expiry a token is already replaced, so a
+// request does not start with a token that expires while it is in flight.
+const expiryMargin = time.Minute
+
+var ErrTokenRefresh = errors.New("failed to refresh access token")
+
+// RefreshFunc obtains a new token for the plugin.
+type RefreshFunc func(ctx context.Context) (*oauth2.Token, error)
+
+type HealthState string
+
+const (
+	HealthStateHealthy   HealthState = "healthy"
+	HealthStateUnhealthy HealthState = "unhealthy"
+)
+
+// Health describes whether the plugin currently holds a usable token.
+type Health struct {
+	State       HealthState
+	Error       error
+	Expiry      time.Time
+	RefreshedAt time.Time
+}
+
+// TokenSource is an oauth2.TokenSource that caches the current token and
+// obtains a new one once it is about to expire. It is safe for concurrent use,
+// all requests share the same token and at most one refresh runs at a time.
+type TokenSource struct {
+	ctx     context.Context
+	refresh RefreshFunc
+
+	mu     sync.Mutex
+	token  *oauth2.Token
+	health Health
+}
+
+// NewTokenSource creates a token source that starts with the given token.
+// Refreshes run with ctx, as oauth2.TokenSource does not pass a context.
+func NewTokenSource(ctx context.Context, token *oauth2.Token, refresh RefreshFunc) *TokenSource {
+	return &TokenSource{
+		ctx:     ctx,
+		refresh: refresh,
+		token:   token,
+		health: Health{
+			State:       HealthStateHealthy,
+			Expiry:      token.Expiry,
+			RefreshedAt: time.Now(),
+		},
+	}
+}
+
+// Token returns the cached token or refreshes it if it is about to expire.
+func (s *TokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.valid() {
+		return s.token, nil
+	}
+
+	return s.refreshLocked()
+}
+
+// Invalidate drops the cached token, so the next call to Token refreshes it.
+// It is used when the backend rejected a token that has not expired yet.
+func (s *TokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.token = nil
+}
+
+func (s *TokenSource) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.health
+}
+
+func (s *TokenSource) valid() bool {
+	if s.token == nil || s.token.AccessToken == "" {
+		return false
+	}
+	return s.token.Expiry.IsZero() || time.Until(s.token.Expiry) > expiryMargin
+}
+
+func (s *TokenSource) refreshLocked() (*oauth2.Token, error) {
+	token, err := s.refresh(s.ctx)
+	if err == nil && (token == nil || token.AccessToken == "") {
+		err = errors.New("received an empty token")
+	}
+	if err != nil {
+		err = errors.Wrap(ErrTokenRefresh, err.Error())
+		s.health.State = HealthStateUnhealthy
+		s.health.Error = err
+		slog.Error("Failed to refresh access token", "error", err)
+		return nil, err
+	}
+
+	s.token = token
+	s.health = Health{
+		State:       HealthStateHealthy,
+		Expiry:      token.Expiry,
+		RefreshedAt: time.Now(),
+	}
+	slog.Info("Refreshed access token", "expiry", token.Expiry)
+
+	return token, nil
+}
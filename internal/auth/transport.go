@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// NewHTTPClient returns a client that authorizes requests with the tokens of
+// the source. Unlike oauth2.NewClient it does not wrap the source in another
+// cache, so the expiry margin and Invalidate take effect. A request the backend
+// rejects with 401 invalidates the token and is sent once more with a new one.
+func NewHTTPClient(source *TokenSource) *http.Client {
+	return &http.Client{
+		Transport: &unauthorizedTransport{
+			source: source,
+			next:   &oauth2.Transport{Source: source},
+		},
+	}
+}
+
+// unauthorizedTransport renews the token when the backend rejects it before it
+// expired, for example after the plugin was registered again.
+type unauthorizedTransport struct {
+	source *TokenSource
+	next   http.RoundTripper
+}
+
+func (t *unauthorizedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	t.source.Invalidate()
+
+	// the request was rejected, so it is safe to send it again if its body can
+	// be read once more
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return t.next.RoundTrip(retry)
+}
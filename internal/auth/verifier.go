@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/pkg/errors"
+)
+
+// keysFetchTimeout limits a request for the signing keys of the identity
+// provider.
+const keysFetchTimeout = 10 * time.Second
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// VerifierConfig configures how the bearer tokens issued by Green Ecolution
+// are verified.
+type VerifierConfig struct {
+	// Disabled turns token verification off, requests are not authenticated.
+	// It has to be set explicitly, a missing JWKSURL is an error otherwise.
+	Disabled bool
+	// JWKSURL is where the public keys of the identity provider are published.
+	JWKSURL string
+	// Issuer is compared with the iss claim if set.
+	Issuer string
+	// Audience is the client id of the plugin. It must be in the aud claim and
+	// the roles are read from its client roles.
+	Audience string
+	// UserClaim is the claim that identifies the user.
+	UserClaim string
+	// RequiredRole is a role the user needs to import trees. Any
+	// authenticated user may import if it is empty.
+	RequiredRole string
+}
+
+// LoadVerifierConfig reads the verifier configuration from the environment.
+func LoadVerifierConfig() (VerifierConfig, error) {
+	cfg := VerifierConfig{
+		JWKSURL:      strings.TrimSpace(os.Getenv("AUTH_JWKS_URL")),
+		Issuer:       strings.TrimSpace(os.Getenv("AUTH_ISSUER")),
+		Audience:     strings.TrimSpace(os.Getenv("AUTH_AUDIENCE")),
+		UserClaim:    strings.TrimSpace(os.Getenv("AUTH_USER_CLAIM")),
+		RequiredRole: strings.TrimSpace(os.Getenv("AUTH_REQUIRED_ROLE")),
+	}
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = "preferred_username"
+	}
+
+	if disabled := strings.TrimSpace(os.Getenv("AUTH_DISABLED")); disabled != "" {
+		var err error
+		if cfg.Disabled, err = strconv.ParseBool(disabled); err != nil {
+			return cfg, errors.Errorf("invalid AUTH_DISABLED %q: expected true or false", disabled)
+		}
+	}
+
+	return cfg, nil
+}
+
+// User is the verified identity of a request.
+type User struct {
+	ID    string
+	Name  string
+	Roles []string
+}
+
+func (u *User) HasRole(role string) bool {
+	return slices.Contains(u.Roles, role)
+}
+
+// Verifier checks RS256 signed JWTs against the keys of the identity provider.
+// It is safe for concurrent use.
+type Verifier struct {
+	cfg      VerifierConfig
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewVerifier(cfg VerifierConfig) (*Verifier, error) {
+	if cfg.JWKSURL == "" {
+		return nil, errors.New("JWKS URL is missing, please set AUTH_JWKS_URL or AUTH_DISABLED=true to run without authentication")
+	}
+	if cfg.Audience == "" {
+		return nil, errors.New("audience is missing, please set AUTH_AUDIENCE to the client id of the plugin")
+	}
+
+	// the key set keeps the context to fetch the keys later
+	ctx := oidc.ClientContext(context.Background(), &http.Client{
+		Transport: keysTransport{next: http.DefaultTransport},
+		Timeout:   keysFetchTimeout,
+	})
+	keys := &keySet{remote: oidc.NewRemoteKeySet(ctx, cfg.JWKSURL)}
+
+	return &Verifier{
+		cfg: cfg,
+		verifier: oidc.NewVerifier(cfg.Issuer, keys, &oidc.Config{
+			ClientID:             cfg.Audience,
+			SupportedSigningAlgs: []string{oidc.RS256},
+			SkipIssuerCheck:      cfg.Issuer == "",
+		}),
+	}, nil
+}
+
+func (v *Verifier) RequiredRole() string {
+	return v.cfg.RequiredRole
+}
+
+type roleClaim struct {
+	Roles []string `json:"roles"`
+}
+
+// Verify checks the signature and the claims of the token and returns the
+// user it was issued to. Roles are read from the client roles of the plugin,
+// roles of other clients or the realm are ignored. An error that does not wrap
+// ErrInvalidToken means the signing keys could not be fetched.
+func (v *Verifier) Verify(ctx context.Context, rawToken string) (*User, error) {
+	var fetchErr error
+	token, err := v.verifier.Verify(context.WithValue(ctx, fetchErrKey{}, &fetchErr), rawToken)
+	if fetchErr != nil {
+		return nil, errors.Wrap(fetchErr, "failed to fetch signing keys")
+	}
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	var claims struct {
+		Name           string               `json:"name"`
+		ResourceAccess map[string]roleClaim `json:"resource_access"`
+	}
+	var rawClaims map[string]any
+	if err := token.Claims(&claims); err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, "malformed token claims")
+	}
+	if err := token.Claims(&rawClaims); err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, "malformed token claims")
+	}
+
+	userID, _ := rawClaims[v.cfg.UserClaim].(string)
+	if userID == "" {
+		return nil, errors.Wrapf(ErrInvalidToken, "token has no %s claim", v.cfg.UserClaim)
+	}
+
+	return &User{
+		ID:    userID,
+		Name:  claims.Name,
+		Roles: slices.Clone(claims.ResourceAccess[v.cfg.Audience].Roles),
+	}, nil
+}
+
+type fetchErrKey struct{}
+
+// keySet records failed fetches of the signing keys in the request context.
+// The oidc verifier reports them like invalid signatures, but they must not
+// lead to a 401 as the token may be valid.
+type keySet struct {
+	remote *oidc.RemoteKeySet
+}
+
+func (k *keySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	payload, err := k.remote.VerifySignature(ctx, jwt)
+	// every failed request of the HTTP client is a *url.Error, including the
+	// responses keysTransport rejects
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if fetchErr, ok := ctx.Value(fetchErrKey{}).(*error); ok {
+			*fetchErr = err
+		}
+	}
+	return payload, err
+}
+
+// keysResponseError is returned when the identity provider does not answer
+// with a key set.
+type keysResponseError struct {
+	Status string
+	Reason string
+}
+
+func (e *keysResponseError) Error() string {
+	return fmt.Sprintf("identity provider responded with %s: %s", e.Status, e.Reason)
+}
+
+// maxKeysSize limits the size of a key set that is read.
+const maxKeysSize = 1 << 20
+
+// keysTransport fails requests for the signing keys that are not answered with
+// a key set. The oidc package reports such responses with untyped errors, as a
+// failed request they are reported as a *url.Error like network errors.
+type keysTransport struct {
+	next http.RoundTripper
+}
+
+func (t keysTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &keysResponseError{Status: resp.Status, Reason: "expected 200 OK"}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxKeysSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxKeysSize {
+		return nil, &keysResponseError{Status: resp.Status, Reason: "key set is too large"}
+	}
+	if !json.Valid(body) {
+		return nil, &keysResponseError{Status: resp.Status, Reason: "key set is not valid JSON"}
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/pkg/errors"
+)
+
+func TestVerifierVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	}))
+	defer jwks.Close()
+
+	verifier, err := NewVerifier(VerifierConfig{
+		JWKSURL:   jwks.URL,
+		Issuer:    "https://idp.example",
+		Audience:  "csv-import",
+		UserClaim: "preferred_username",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := map[string]any{
+		"iss":                "https://idp.example",
+		"aud":                "csv-import",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"preferred_username": "jane",
+		"realm_access":       map[string]any{"roles": []string{"admin"}},
+		"resource_access": map[string]any{
+			"csv-import": map[string]any{"roles": []string{"importer"}},
+			"other":      map[string]any{"roles": []string{"admin"}},
+		},
+	}
+	with := func(name string, value any) map[string]any {
+		claims := make(map[string]any, len(valid))
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[name] = value
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		claims  map[string]any
+		invalid bool
+	}{
+		{name: "valid", claims: valid},
+		{name: "expired", claims: with("exp", time.Now().Add(-time.Hour).Unix()), invalid: true},
+		{name: "other audience", claims: with("aud", "other"), invalid: true},
+		{name: "other issuer", claims: with("iss", "https://evil.example"), invalid: true},
+		{name: "missing user", claims: with("preferred_username", ""), invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := verifier.Verify(context.Background(), signToken(t, key, tt.claims))
+			if tt.invalid {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("expected ErrInvalidToken, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != "jane" || !slices.Equal(user.Roles, []string{"importer"}) {
+				t.Errorf("unexpected user %+v", user)
+			}
+		})
+	}
+
+	// a signature of an unknown key is invalid, not a failed key fetch
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(context.Background(), signToken(t, otherKey, valid)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for an unknown key, got %v", err)
+	}
+}
+
+func TestVerifierUnavailableKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signToken(t, key, map[string]any{"aud": "csv-import", "sub": "jane", "exp": time.Now().Add(time.Hour).Unix()})
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{name: "server error", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}},
+		{name: "no key set", handler: func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("<html>maintenance</html>"))
+		}},
+		{name: "unreachable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwksURL := unreachable.URL
+			if tt.handler != nil {
+				jwks := httptest.NewServer(tt.handler)
+				defer jwks.Close()
+				jwksURL = jwks.URL
+			}
+
+			verifier, err := NewVerifier(VerifierConfig{JWKSURL: jwksURL, Audience: "csv-import", UserClaim: "sub"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for range 2 {
+				if _, err := verifier.Verify(context.Background(), token); err == nil || errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("expected a key fetch error, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadVerifierConfig(t *testing.T) {
+	t.Setenv("AUTH_DISABLED", "true")
+	cfg, err := LoadVerifierConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Disabled {
+		t.Error("expected verification to be disabled")
+	}
+
+	t.Setenv("AUTH_DISABLED", "maybe")
+	if _, err := LoadVerifierConfig(); err == nil {
+		t.Error("expected an error for an invalid AUTH_DISABLED")
+	}
+}
+
+func TestNewVerifierRequiresJWKSURL(t *testing.T) {
+	if _, err := NewVerifier(VerifierConfig{Audience: "csv-import"}); err == nil {
+		t.Fatal("expected an error without JWKS URL")
+	}
+}
+
+func TestNewVerifierRequiresAudience(t *testing.T) {
+	if _, err := NewVerifier(VerifierConfig{JWKSURL: "https://idp.example/certs"}); err == nil {
+		t.Fatal("expected an error without audience")
+	}
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
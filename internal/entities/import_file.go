@@ -0,0 +1,19 @@
+package entities
+
+import "time"
+
+// ImportFile is the CSV file an import was created from, exactly as it was
+// uploaded.
+type ImportFile struct {
+	ImportID  ImportID  `db:"import_id"`
+	CreatedAt time.Time `db:"created_at"`
+	Filename  string    `db:"filename"`
+	// SHA256 is the hex encoded checksum of Content.
+	SHA256   string `db:"sha256"`
+	Encoding string `db:"encoding"`
+	Size     int64  `db:"size"`
+	// Content is set when the file is read back.
+	Content []byte `db:"-"`
+	// Compressed is the gzip compressed content of a file that is stored.
+	Compressed []byte `db:"-"`
+}
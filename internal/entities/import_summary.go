@@ -0,0 +1,46 @@
+package entities
+
+import "time"
+
+// ImportSummary is an import together with the number of operations that
+// were applied, failed or compensated.
+type ImportSummary struct {
+	ID                     ImportID     `db:"id"`
+	CreatedAt              time.Time    `db:"created_at"`
+	UserID                 UserID       `db:"user_id"`
+	Status                 ImportStatus `db:"status"`
+	FinishedAt             *time.Time   `db:"finished_at"`
+	Error                  *string      `db:"error"`
+	SourceEPSG             *int         `db:"source_epsg"`
+	DetectedEPSG           *int         `db:"detected_epsg"`
+	Transformation         *string      `db:"transformation"`
+	TransformationAccuracy *float64     `db:"transformation_accuracy"`
+	Created                int          `db:"created_count"`
+	Updated                int          `db:"updated_count"`
+	Deleted                int          `db:"deleted_count"`
+	Failed                 int          `db:"failed_count"`
+	Compensated            int          `db:"compensated_count"`
+}
+
+// Duration returns how long the import ran, or zero if it has not finished.
+func (s ImportSummary) Duration() time.Duration {
+	if s.FinishedAt == nil {
+		return 0
+	}
+	return s.FinishedAt.Sub(s.CreatedAt)
+}
+
+// ImportFilter selects imports from the history. Zero values do not filter.
+type ImportFilter struct {
+	From   time.Time
+	To     time.Time
+	UserID UserID
+	Limit  int
+	Offset int
+}
+
+// TreeConflict is a tree that was changed by a later import.
+type TreeConflict struct {
+	TreeID   TreeID   `db:"tree_id"`
+	ImportID ImportID `db:"import_id"`
+}
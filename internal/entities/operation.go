@@ -0,0 +1,48 @@
+package entities
+
+import "time"
+
+// ImportOperation is an entry of the import journal. It is written before the
+// backend is called and records the outcome of the call. Payload holds the JSON
+// encoded tree that is sent to the backend, Previous the JSON encoded tree as it
+// was before an update or delete, so the operation can be compensated. Diff
+// holds the JSON encoded []FieldChange of an update.
+type ImportOperation struct {
+	ID        ImportOperationID `db:"id"`
+	CreatedAt time.Time         `db:"created_at"`
+	UpdatedAt time.Time         `db:"updated_at"`
+	ImportID  ImportID          `db:"import_id"`
+	TreeID    *TreeID           `db:"tree_id"`
+	BackendID *TreeBackendID    `db:"backend_id"`
+	Operation OperationType     `db:"operation"`
+	Status    OperationStatus   `db:"status"`
+	Payload   *string           `db:"payload"`
+	Previous  *string           `db:"previous"`
+	Diff      *string           `db:"diff"`
+	Attempts  int32             `db:"attempts"`
+	LastError *string           `db:"last_error"`
+}
+
+type ImportOperationID = int32
+type OperationType = string
+type OperationStatus = string
+
+const (
+	OperationCreate OperationType = "create"
+	OperationUpdate OperationType = "update"
+	OperationDelete OperationType = "delete"
+)
+
+const (
+	OperationStatusPending     OperationStatus = "pending"
+	OperationStatusApplied     OperationStatus = "applied"
+	OperationStatusFailed      OperationStatus = "failed"
+	OperationStatusCompensated OperationStatus = "compensated"
+)
+
+// FieldChange is a single attribute that an update changed.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
@@ -13,6 +13,7 @@ type Tree struct {
 	Longitude    TreeLongitude    `db:"longitude"`
 	PlantingYear TreePlantingYear `db:"planting_year"`
 	Street       TreeStreet       `db:"street"`
+	BackendID    *TreeBackendID   `db:"backend_id"`
 }
 
 type TreeArea = string
@@ -23,15 +24,36 @@ type TreeLongitude = float64
 type TreePlantingYear = int32
 type TreeStreet = string
 type TreeID = int32
+type TreeBackendID = int32
 
 type Import struct {
-	ID        ImportID  `db:"id"`
-	CreatedAt time.Time `db:"created_at"`
-	UserID    UserID    `db:"user_id"`
-	RawCSV    RawCSV    `db:"raw_csv"`
+	ID         ImportID     `db:"id"`
+	CreatedAt  time.Time    `db:"created_at"`
+	UserID     UserID       `db:"user_id"`
+	RawCSV     RawCSV       `db:"raw_csv"`
+	Status     ImportStatus `db:"status"`
+	FinishedAt *time.Time   `db:"finished_at"`
+	Error      *string      `db:"error"`
+	// ContentHash identifies the content of the imported CSV file.
+	ContentHash *string `db:"content_hash"`
+	// SourceEPSG is the CRS the coordinates of the CSV file were read in and
+	// DetectedEPSG the one detected from the coordinates, if any.
+	SourceEPSG   *int `db:"source_epsg"`
+	DetectedEPSG *int `db:"detected_epsg"`
+	// Transformation is the PROJ pipeline the coordinates were transformed
+	// with and TransformationAccuracy its expected accuracy in metres.
+	Transformation         *string  `db:"transformation"`
+	TransformationAccuracy *float64 `db:"transformation_accuracy"`
 }
 
 type ImportID = int32
 type UserID = string
 type RawCSV = string
+type ImportStatus = string
 
+const (
+	ImportStatusRunning          ImportStatus = "running"
+	ImportStatusSucceeded        ImportStatus = "succeeded"
+	ImportStatusPartiallyApplied ImportStatus = "partially_applied"
+	ImportStatusRolledBack       ImportStatus = "rolled_back"
+)
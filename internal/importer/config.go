@@ -0,0 +1,379 @@
+package importer
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/importer/storage"
+	"github.com/pkg/errors"
+)
+
+// ConverterConfig configures how CSV files are read and converted. It is
+// loaded and validated once at startup and passed to every converter.
+type ConverterConfig struct {
+	// Columns maps the tree fields to the accepted CSV header names.
+	Columns ColumnMapping
+	// FromEPSG is the coordinate reference system used in the CSV file. It may
+	// be left out if the CRS is detected automatically.
+	FromEPSG int
+	// ToEPSG is the coordinate reference system the trees are converted to.
+	ToEPSG int
+	// MaxInvalidRows is the number of invalid rows that are skipped before the
+	// whole file is rejected. A negative value allows any number.
+	MaxInvalidRows int
+	// BoundingBox is the area converted trees have to lie in. Trees outside
+	// are reported as invalid rows. Nil disables the check.
+	BoundingBox *BoundingBox
+	// CRSDetection decides whether the source CRS is checked against or
+	// detected from the coordinates. Without a bounding box the configured
+	// CRS is not verified and automatic detection is not possible.
+	CRSDetection CRSDetectionMode
+	// CRSCandidates are the source CRS the detection chooses from.
+	CRSCandidates []int
+	// GridFile is the path of an NTv2 grid file, e.g. BeTA2007, used to shift
+	// DHDN based coordinates. PROJ's default transformation is used if empty.
+	GridFile string
+	// Transformer selects the implementation that transforms the coordinates.
+	Transformer TransformerBackend
+}
+
+var DefaultConverterConfig = ConverterConfig{
+	Columns:        DefaultColumnMapping,
+	ToEPSG:         4326, // WGS84
+	MaxInvalidRows: 0,
+	BoundingBox:    &FlensburgBoundingBox,
+	CRSDetection:   CRSDetectionVerify,
+	// Gauss-Krüger zone 3, ETRS89 / UTM zone 32N and WGS84
+	CRSCandidates: []int{31467, 25832, 4326},
+	Transformer:   DefaultTransformerBackend,
+}
+
+// LoadConverterConfig reads the converter configuration from the environment.
+// The returned configuration is already validated.
+func LoadConverterConfig() (ConverterConfig, error) {
+	cfg := DefaultConverterConfig
+
+	columns, err := ParseColumnMapping(os.Getenv("CSV_COLUMNS"))
+	if err != nil {
+		return cfg, errors.Wrap(err, "invalid CSV_COLUMNS")
+	}
+	cfg.Columns = columns
+
+	// CSV_HEADERS lists the header names in the order area, street, number,
+	// species, Hochwert, Rechtswert, Pflanzjahr and is still accepted as aliases.
+	if csvHeaders := strings.TrimSpace(os.Getenv("CSV_HEADERS")); csvHeaders != "" {
+		expectedCSVHeaders := strings.Split(csvHeaders, ",")
+		if len(expectedCSVHeaders) != len(csvFields) {
+			return cfg, errors.Errorf("invalid CSV_HEADERS: expected %d comma separated headers, got %d", len(csvFields), len(expectedCSVHeaders))
+		}
+		for i, field := range csvFields {
+			cfg.Columns = cfg.Columns.WithAliases(field, strings.TrimSpace(expectedCSVHeaders[i]))
+		}
+	}
+
+	if cfg.FromEPSG, err = envInt("CSV_USED_EPSG", cfg.FromEPSG); err != nil {
+		return cfg, err
+	}
+
+	if cfg.ToEPSG, err = envInt("CSV_TO_EPSG", cfg.ToEPSG); err != nil {
+		return cfg, err
+	}
+
+	if cfg.MaxInvalidRows, err = envInt("CSV_MAX_INVALID_ROWS", cfg.MaxInvalidRows); err != nil {
+		return cfg, err
+	}
+
+	// CSV_BOUNDING_BOX is "min_lat,min_lng,max_lat,max_lng" or "none"
+	if boundingBox := strings.TrimSpace(os.Getenv("CSV_BOUNDING_BOX")); strings.EqualFold(boundingBox, "none") {
+		cfg.BoundingBox = nil
+	} else if boundingBox != "" {
+		box, err := ParseBoundingBox(boundingBox)
+		if err != nil {
+			return cfg, errors.Wrap(err, "invalid CSV_BOUNDING_BOX")
+		}
+		cfg.BoundingBox = &box
+	}
+
+	cfg.GridFile = strings.TrimSpace(os.Getenv("CSV_NTV2_GRID_FILE"))
+
+	if transformer := os.Getenv("CSV_TRANSFORMER"); transformer != "" {
+		if cfg.Transformer, err = ParseTransformerBackend(transformer); err != nil {
+			return cfg, errors.Wrap(err, "invalid CSV_TRANSFORMER")
+		}
+	}
+
+	if detection := os.Getenv("CSV_CRS_DETECTION"); detection != "" {
+		if cfg.CRSDetection, err = ParseCRSDetectionMode(detection); err != nil {
+			return cfg, errors.Wrap(err, "invalid CSV_CRS_DETECTION")
+		}
+	}
+
+	if candidates := strings.TrimSpace(os.Getenv("CSV_CRS_CANDIDATES")); candidates != "" {
+		cfg.CRSCandidates = nil
+		for _, candidate := range strings.Split(candidates, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(candidate))
+			if err != nil {
+				return cfg, errors.Errorf("invalid CSV_CRS_CANDIDATES %q: %q is not an EPSG code", candidates, candidate)
+			}
+			cfg.CRSCandidates = append(cfg.CRSCandidates, code)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks that the configuration is complete. Whether coordinates can
+// be transformed from the configured EPSG codes is checked when the
+// transformers are created with NewGeoTransformers.
+func (c ConverterConfig) Validate() error {
+	if _, err := ParseCRSDetectionMode(string(c.CRSDetection)); err != nil {
+		return err
+	}
+
+	if _, err := ParseTransformerBackend(string(c.Transformer)); err != nil {
+		return err
+	}
+
+	if c.FromEPSG <= 0 && c.CRSDetection != CRSDetectionAuto {
+		return errors.New("source EPSG code is missing, please set CSV_USED_EPSG or enable automatic detection")
+	}
+
+	if c.ToEPSG <= 0 {
+		return errors.Errorf("invalid target EPSG code %d", c.ToEPSG)
+	}
+
+	for _, field := range csvFields {
+		if len(c.Columns[field]) == 0 {
+			return errors.Errorf("no CSV header names configured for field %s", field)
+		}
+	}
+
+	if c.BoundingBox != nil {
+		if err := c.BoundingBox.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.GridFile != "" {
+		if err := checkGridFile(c.GridFile); err != nil {
+			return errors.Wrap(err, "invalid CSV_NTV2_GRID_FILE")
+		}
+	}
+
+	if c.CRSDetection == CRSDetectionAuto && c.BoundingBox == nil {
+		return errors.New("automatic CRS detection needs a bounding box, please set CSV_BOUNDING_BOX")
+	}
+
+	if c.CRSDetection != CRSDetectionOff && c.BoundingBox != nil && len(c.CRSCandidates) == 0 {
+		return errors.New("no candidate EPSG codes configured for the CRS detection, please set CSV_CRS_CANDIDATES")
+	}
+
+	return nil
+}
+
+// ImportConfig configures how converted trees are imported.
+type ImportConfig struct {
+	Match MatchConfig
+	// SyncMode is used for imports that do not choose a mode themselves.
+	SyncMode SyncMode
+	// MaxDeletions is the number of trees one import may delete without an
+	// explicit confirmation. A negative value allows any number.
+	MaxDeletions int
+	// CompensateOnFailure reverts the already applied operations when an
+	// import fails. Otherwise the import is left partially applied.
+	CompensateOnFailure bool
+	// Duplicates decides what happens to uploads whose content was already
+	// imported.
+	Duplicates DuplicatePolicy
+	// Concurrency is the number of trees written to the backend at once.
+	Concurrency int
+	Retry       storage.RetryConfig
+	// CoordinateDecimals is the number of decimals positions are rounded to
+	// before they are stored and sent to the backend.
+	CoordinateDecimals int
+	// RoundTripTolerance is the distance in metres the position stored by the
+	// backend may differ from the one sent without a warning.
+	RoundTripTolerance float64
+}
+
+var DefaultImportConfig = ImportConfig{
+	Match:               DefaultMatchConfig,
+	SyncMode:            SyncModeAdditive,
+	MaxDeletions:        50,
+	CompensateOnFailure: true,
+	Duplicates:          DuplicatePolicyReject,
+	Concurrency:         8,
+	Retry:               storage.DefaultRetryConfig,
+	// 7 decimals are about a centimetre
+	CoordinateDecimals: 7,
+	// positions are sent as float64, the backend should store them unchanged
+	RoundTripTolerance: 0.05,
+}
+
+// LoadImportConfig reads the import configuration from the environment. The
+// returned configuration is already validated.
+func LoadImportConfig() (ImportConfig, error) {
+	cfg := DefaultImportConfig
+
+	var err error
+	if cfg.Match.Tolerance, err = envFloat("IMPORT_MATCH_TOLERANCE", cfg.Match.Tolerance); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Match.MatchNumber, err = envBool("IMPORT_MATCH_NUMBER", cfg.Match.MatchNumber); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Match.MatchArea, err = envBool("IMPORT_MATCH_AREA", cfg.Match.MatchArea); err != nil {
+		return cfg, err
+	}
+
+	if syncMode := os.Getenv("IMPORT_SYNC_MODE"); syncMode != "" {
+		if cfg.SyncMode, err = ParseSyncMode(syncMode); err != nil {
+			return cfg, errors.Wrap(err, "invalid IMPORT_SYNC_MODE")
+		}
+	}
+
+	if cfg.MaxDeletions, err = envInt("IMPORT_MAX_DELETIONS", cfg.MaxDeletions); err != nil {
+		return cfg, err
+	}
+
+	if cfg.CompensateOnFailure, err = envBool("IMPORT_COMPENSATE_ON_FAILURE", cfg.CompensateOnFailure); err != nil {
+		return cfg, err
+	}
+
+	if duplicates := os.Getenv("IMPORT_DUPLICATES"); duplicates != "" {
+		if cfg.Duplicates, err = ParseDuplicatePolicy(duplicates); err != nil {
+			return cfg, errors.Wrap(err, "invalid IMPORT_DUPLICATES")
+		}
+	}
+
+	if cfg.Concurrency, err = envInt("IMPORT_CONCURRENCY", cfg.Concurrency); err != nil {
+		return cfg, err
+	}
+
+	if cfg.CoordinateDecimals, err = envInt("IMPORT_COORDINATE_DECIMALS", cfg.CoordinateDecimals); err != nil {
+		return cfg, err
+	}
+
+	if cfg.RoundTripTolerance, err = envFloat("IMPORT_ROUND_TRIP_TOLERANCE", cfg.RoundTripTolerance); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Retry.MaxAttempts, err = envInt("BACKEND_MAX_ATTEMPTS", cfg.Retry.MaxAttempts); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Retry.InitialBackoff, err = envDuration("BACKEND_INITIAL_BACKOFF", cfg.Retry.InitialBackoff); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Retry.MaxBackoff, err = envDuration("BACKEND_MAX_BACKOFF", cfg.Retry.MaxBackoff); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Retry.RequestTimeout, err = envDuration("BACKEND_REQUEST_TIMEOUT", cfg.Retry.RequestTimeout); err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+func (c ImportConfig) Validate() error {
+	if _, err := ParseSyncMode(string(c.SyncMode)); err != nil {
+		return err
+	}
+
+	if c.Match.Tolerance < 0 || math.IsNaN(c.Match.Tolerance) || math.IsInf(c.Match.Tolerance, 0) {
+		return errors.Errorf("invalid match tolerance %v, expected a distance in metres", c.Match.Tolerance)
+	}
+
+	if _, err := ParseDuplicatePolicy(string(c.Duplicates)); err != nil {
+		return err
+	}
+
+	if c.Concurrency < 1 {
+		return errors.Errorf("invalid concurrency %d, expected at least 1", c.Concurrency)
+	}
+
+	if c.CoordinateDecimals < 0 || c.CoordinateDecimals > maxCoordinateDecimals {
+		return errors.Errorf("invalid coordinate decimals %d, expected 0 to %d", c.CoordinateDecimals, maxCoordinateDecimals)
+	}
+
+	if c.RoundTripTolerance < 0 || math.IsNaN(c.RoundTripTolerance) || math.IsInf(c.RoundTripTolerance, 0) {
+		return errors.Errorf("invalid round trip tolerance %v, expected a distance in metres", c.RoundTripTolerance)
+	}
+
+	if err := c.Retry.Validate(); err != nil {
+		return errors.Wrap(err, "invalid backend retry configuration")
+	}
+
+	return nil
+}
+
+func envInt(key string, fallback int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Errorf("invalid %s %q: not an integer", key, value)
+	}
+
+	return parsed, nil
+}
+
+func envFloat(key string, fallback float64) (float64, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid %s %q: not a number", key, value)
+	}
+
+	return parsed, nil
+}
+
+func envBool(key string, fallback bool) (bool, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.Errorf("invalid %s %q: expected true or false", key, value)
+	}
+
+	return parsed, nil
+}
+
+func envDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Errorf("invalid %s %q: expected a duration like 500ms or 30s", key, value)
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,47 @@
+package importer
+
+import (
+	"log/slog"
+	"math"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/importer/storage"
+)
+
+// maxCoordinateDecimals is the most decimals a float64 degree value holds.
+const maxCoordinateDecimals = 15
+
+// normalizeCoordinate rounds a coordinate in degrees to the given number of
+// decimals.
+func normalizeCoordinate(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
+
+// normalizeCoordinates rounds the positions of the trees, so the local store
+// and the backend receive the same values and later imports compare equal.
+func normalizeCoordinates(trees []*entities.Tree, decimals int) {
+	for _, tree := range trees {
+		tree.Latitude = normalizeCoordinate(tree.Latitude, decimals)
+		tree.Longitude = normalizeCoordinate(tree.Longitude, decimals)
+	}
+}
+
+// checkRoundTrip warns when the backend stored a tree at another position
+// than it was sent with.
+func (i *ImportService) checkRoundTrip(tree *entities.Tree, stored storage.Position) {
+	distance := Distance(tree.Latitude, tree.Longitude, stored.Latitude, stored.Longitude)
+	if distance <= i.cfg.RoundTripTolerance {
+		return
+	}
+
+	slog.Warn("Backend stored tree at a different position",
+		"tree_number", tree.Number,
+		"backend_id", tree.BackendID,
+		"distance", distance,
+		"sent_latitude", tree.Latitude,
+		"sent_longitude", tree.Longitude,
+		"stored_latitude", stored.Latitude,
+		"stored_longitude", stored.Longitude,
+	)
+}
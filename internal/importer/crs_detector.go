@@ -0,0 +1,146 @@
+package importer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CRSDetectionMode decides how the source CRS of a CSV file is chosen.
+type CRSDetectionMode string
+
+const (
+	// CRSDetectionOff trusts the configured source CRS.
+	CRSDetectionOff CRSDetectionMode = "off"
+	// CRSDetectionVerify refuses files whose coordinates fit another
+	// candidate better than the configured source CRS.
+	CRSDetectionVerify CRSDetectionMode = "verify"
+	// CRSDetectionAuto uses the candidate that fits the coordinates best.
+	CRSDetectionAuto CRSDetectionMode = "auto"
+)
+
+func ParseCRSDetectionMode(s string) (CRSDetectionMode, error) {
+	switch mode := CRSDetectionMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case CRSDetectionOff, CRSDetectionVerify, CRSDetectionAuto:
+		return mode, nil
+	default:
+		return "", errors.Errorf("unknown CRS detection mode %q, expected %q, %q or %q", s, CRSDetectionOff, CRSDetectionVerify, CRSDetectionAuto)
+	}
+}
+
+// minDetectionShare is the share of sampled points that have to lie in the
+// bounding box for a candidate to be detected.
+const minDetectionShare = 0.9
+
+// CRSScore tells how many of the sampled points lie in the bounding box when
+// they are read in the given CRS.
+type CRSScore struct {
+	EPSG   int
+	Inside int
+	Total  int
+}
+
+func (s CRSScore) Share() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Inside) / float64(s.Total)
+}
+
+// CRSDetection describes which source CRS was used to convert a CSV file.
+// Detected is zero if detection is disabled or no candidate fit.
+type CRSDetection struct {
+	Mode       CRSDetectionMode
+	Configured int
+	Detected   int
+	Used       int
+	Scores     []CRSScore
+}
+
+// CRSMismatchError is returned when the coordinates of a file fit another
+// candidate than the configured source CRS.
+type CRSMismatchError struct {
+	Configured int
+	Detected   int
+	Scores     []CRSScore
+}
+
+func (e *CRSMismatchError) Error() string {
+	scores := make([]string, 0, len(e.Scores))
+	for _, score := range e.Scores {
+		scores = append(scores, fmt.Sprintf("EPSG %d: %d/%d", score.EPSG, score.Inside, score.Total))
+	}
+	return fmt.Sprintf("coordinates look like EPSG %d but EPSG %d is configured (points inside the area: %s)", e.Detected, e.Configured, strings.Join(scores, ", "))
+}
+
+// CRSDetector scores candidate coordinate reference systems by how many
+// points end up in the bounding box after the transformation. It is safe for
+// concurrent use.
+type CRSDetector struct {
+	box          BoundingBox
+	candidates   []int
+	transformers map[int]GeoTransformer
+}
+
+// NewCRSDetector creates a detector that transforms the points with the
+// transformers of the candidates.
+func NewCRSDetector(candidates []int, transformers map[int]GeoTransformer, box BoundingBox) (*CRSDetector, error) {
+	if len(candidates) == 0 {
+		return nil, errors.New("no candidate EPSG codes configured")
+	}
+
+	for _, candidate := range candidates {
+		if _, ok := transformers[candidate]; !ok {
+			return nil, errors.Errorf("no transformer for candidate EPSG %d", candidate)
+		}
+	}
+
+	return &CRSDetector{
+		box:          box,
+		candidates:   slices.Clone(candidates),
+		transformers: transformers,
+	}, nil
+}
+
+// Score transforms the points with every candidate. The scores are sorted by
+// the number of points inside the bounding box, ties keep the configured order.
+func (d *CRSDetector) Score(points []GeoPoint) []CRSScore {
+	scores := make([]CRSScore, 0, len(d.candidates))
+	for _, candidate := range d.candidates {
+		score := CRSScore{EPSG: candidate, Total: len(points)}
+
+		// a failing transformation means the points are not valid in this CRS
+		transformed, err := d.transformers[candidate].TransformBatch(points)
+		var pointErrs *TransformErrors
+		if err == nil || errors.As(err, &pointErrs) {
+			for i, point := range transformed {
+				if pointErrs != nil && pointErrs.Points[i] != nil {
+					continue
+				}
+				if d.box.Contains(point.North, point.East) {
+					score.Inside++
+				}
+			}
+		}
+
+		scores = append(scores, score)
+	}
+
+	slices.SortStableFunc(scores, func(a, b CRSScore) int {
+		return b.Inside - a.Inside
+	})
+
+	return scores
+}
+
+// Detect returns the candidate that puts most points into the bounding box,
+// or zero if no candidate places enough of them there.
+func (d *CRSDetector) Detect(points []GeoPoint) (int, []CRSScore) {
+	scores := d.Score(points)
+	if len(scores) == 0 || scores[0].Share() < minDetectionShare {
+		return 0, scores
+	}
+	return scores[0].EPSG, scores
+}
@@ -0,0 +1,159 @@
+package importer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CSVField is a tree attribute that is read from a CSV column.
+type CSVField string
+
+const (
+	FieldArea         CSVField = "area"
+	FieldStreet       CSVField = "street"
+	FieldNumber       CSVField = "number"
+	FieldSpecies      CSVField = "species"
+	FieldNorthing     CSVField = "northing"
+	FieldEasting      CSVField = "easting"
+	FieldPlantingYear CSVField = "planting_year"
+)
+
+// csvFields lists all fields in the column order of the legacy CSV_HEADERS variable.
+var csvFields = []CSVField{
+	FieldArea,
+	FieldStreet,
+	FieldNumber,
+	FieldSpecies,
+	FieldNorthing,
+	FieldEasting,
+	FieldPlantingYear,
+}
+
+var optionalFields = []CSVField{FieldSpecies}
+
+// ColumnMapping maps each field to the header names that are accepted for it.
+// Header names are compared case-insensitively and without surrounding spaces.
+type ColumnMapping map[CSVField][]string
+
+// DefaultColumnMapping contains the header names used by known TBZ exports.
+var DefaultColumnMapping = ColumnMapping{
+	FieldArea:         {"Gebiet", "Bereich", "Bezirk"},
+	FieldStreet:       {"Straße", "Strasse", "Str."},
+	FieldNumber:       {"Baumnummer", "Baumnr.", "Baumnr", "Baum-Nr."},
+	FieldSpecies:      {"Gattung/Art/Deutscher Name", "Baumart", "Art", "Gattung/Art"},
+	FieldNorthing:     {"Hochwert"},
+	FieldEasting:      {"Rechtswert"},
+	FieldPlantingYear: {"Pflanzjahr"},
+}
+
+// MissingColumnsError is returned when a CSV header does not contain a column
+// for every required field.
+type MissingColumnsError struct {
+	Fields []CSVField
+}
+
+func (e *MissingColumnsError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		fields[i] = string(field)
+	}
+	return fmt.Sprintf("csv file is missing columns for: %s", strings.Join(fields, ", "))
+}
+
+// ParseColumnMapping parses a mapping in the form
+// "number=Baumnummer|Baumnr.;street=Straße". Fields that are not mentioned
+// keep the aliases of DefaultColumnMapping.
+func ParseColumnMapping(s string) (ColumnMapping, error) {
+	mapping := DefaultColumnMapping.Clone()
+
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		field, aliases, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, errors.Errorf("invalid column mapping entry %q, expected field=alias|alias", entry)
+		}
+
+		csvField := CSVField(strings.TrimSpace(field))
+		if !slices.Contains(csvFields, csvField) {
+			return nil, errors.Errorf("unknown field %q in column mapping", field)
+		}
+
+		names := make([]string, 0)
+		for _, alias := range strings.Split(aliases, "|") {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				names = append(names, alias)
+			}
+		}
+		if len(names) == 0 {
+			return nil, errors.Errorf("no header names given for field %q", field)
+		}
+
+		mapping[csvField] = names
+	}
+
+	return mapping, nil
+}
+
+func (m ColumnMapping) Clone() ColumnMapping {
+	clone := make(ColumnMapping, len(m))
+	for field, aliases := range m {
+		clone[field] = slices.Clone(aliases)
+	}
+	return clone
+}
+
+// WithAliases returns a copy of the mapping where the given header names are
+// accepted in addition to the existing ones.
+func (m ColumnMapping) WithAliases(field CSVField, aliases ...string) ColumnMapping {
+	clone := m.Clone()
+	clone[field] = append(slices.Clone(aliases), clone[field]...)
+	return clone
+}
+
+// resolve finds the column index of every field in the header. Unknown columns
+// are ignored, missing optional fields are left out of the result.
+func (m ColumnMapping) resolve(header []string) (map[CSVField]int, error) {
+	indexes := make(map[CSVField]int, len(csvFields))
+	missing := make([]CSVField, 0)
+
+	for _, field := range csvFields {
+		idx := -1
+		for i, h := range header {
+			if !m.matches(field, h) {
+				continue
+			}
+			if idx != -1 {
+				return nil, errors.Errorf("columns '%s' and '%s' both match field %s", header[idx], h, field)
+			}
+			idx = i
+		}
+
+		if idx == -1 {
+			if !slices.Contains(optionalFields, field) {
+				missing = append(missing, field)
+			}
+			continue
+		}
+		indexes[field] = idx
+	}
+
+	if len(missing) > 0 {
+		return nil, &MissingColumnsError{Fields: missing}
+	}
+
+	return indexes, nil
+}
+
+func (m ColumnMapping) matches(field CSVField, header string) bool {
+	header = strings.TrimSpace(header)
+	return slices.ContainsFunc(m[field], func(alias string) bool {
+		return strings.EqualFold(alias, header)
+	})
+}
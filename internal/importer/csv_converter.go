@@ -2,13 +2,15 @@ package importer
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
-	"log"
+	"iter"
 	"log/slog"
-	"os"
-	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -19,232 +21,469 @@ import (
 )
 
 type CSVConverter struct {
-	expectedHeaders []string
-	fromEPSG        int
-	toEPSG          int
-	csvFile         *os.File
+	columns        ColumnMapping
+	fromEPSG       int
+	toEPSG         int
+	maxInvalidRows int
+	boundingBox    *BoundingBox
+	crsDetection   CRSDetectionMode
+	crs            *CRSDetection
+	transformers   *GeoTransformers
+	transformation *Transformation
+	reader         io.Reader
+	dialect        CSVDialect
+	contentHash    hash.Hash
+	rowErrors      []*RowError
 }
 
-func NewCSVConverter(file *os.File) *CSVConverter {
-	expectedCSVHeaders := strings.Split(strings.Trim(os.Getenv("CSV_HEADERS"), " "), ",")
-	if len(expectedCSVHeaders) == 0 {
-		log.Fatalf("Error getting CSV headers from environment variable. Please check the CSV_HEADERS variable.\n")
-	}
+// transformBatchSize is the number of trees whose coordinates are transformed
+// together while streaming.
+const transformBatchSize = 1000
+
+// ConvertResult contains the trees of all valid rows and the errors of all
+// rows that were skipped. Trees is nil if the trees were read with Batches.
+type ConvertResult struct {
+	Trees   []*entities.Tree
+	Errors  []*RowError
+	Dialect CSVDialect
+	// ContentHash identifies the content of the file independent of its
+	// encoding, delimiter, line endings and column order.
+	ContentHash string
+	CRS         *CRSDetection
+	// Transformation is nil if the file contains no trees.
+	Transformation *Transformation
+}
 
-	fromEPSGStr := os.Getenv("CSV_USED_EPSG")
-	fromEPSG, err := strconv.Atoi(fromEPSGStr)
-	if err != nil {
-		log.Fatalf("Error converting EPSG from string to int: %v\n", err)
+// NewCSVConverter creates a converter for CSV data read from r. The
+// configuration has to be validated and the transformers created from it at
+// startup. The input can only be consumed once.
+func NewCSVConverter(cfg ConverterConfig, transformers *GeoTransformers, r io.Reader) *CSVConverter {
+	return &CSVConverter{
+		columns:        cfg.Columns,
+		fromEPSG:       cfg.FromEPSG,
+		toEPSG:         cfg.ToEPSG,
+		maxInvalidRows: cfg.MaxInvalidRows,
+		boundingBox:    cfg.BoundingBox,
+		crsDetection:   cfg.CRSDetection,
+		transformers:   transformers,
+		reader:         r,
 	}
+}
 
-	toEPSGStr := os.Getenv("CSV_TO_EPSG")
-	toEPSG := 4326 // default to WGS84
-	if toEPSGStr != "" {
-		toEPSG, err = strconv.Atoi(toEPSGStr)
+// Convert reads all rows of the CSV file into memory, see Batches.
+func (c *CSVConverter) Convert(ctx context.Context) (*ConvertResult, error) {
+	var trees []*entities.Tree
+	for batch, err := range c.Batches(ctx) {
 		if err != nil {
-			log.Fatalf("Error converting EPSG from string to int: %v\n", err)
+			return nil, err
 		}
+		trees = append(trees, batch...)
 	}
 
-	return &CSVConverter{
-		expectedHeaders: expectedCSVHeaders,
-		fromEPSG:        fromEPSG,
-		toEPSG:          toEPSG,
-		csvFile:         file,
-	}
+	result := c.Result()
+	result.Trees = trees
+	return result, nil
 }
 
-func (c *CSVConverter) Convert(ctx context.Context) ([]*entities.Tree, error) {
-	start := time.Now()
-	if err := c.validateCsv(); err != nil {
-		return nil, err
-	}
+// Batches yields the trees of the valid rows in batches of up to
+// transformBatchSize while the input is read. Invalid rows are skipped and
+// reported by Result. If their number exceeds the configured threshold (a
+// negative threshold allows any number), a *ValidationError containing all row
+// errors ends the sequence after the last batch.
+func (c *CSVConverter) Batches(ctx context.Context) iter.Seq2[[]*entities.Tree, error] {
+	return func(yield func([]*entities.Tree, error) bool) {
+		start := time.Now()
+		trees := 0
+
+		batch := make([]*entities.Tree, 0, transformBatchSize)
+		for tree, err := range c.Trees(ctx) {
+			if err != nil {
+				var rowErr *RowError
+				if errors.As(err, &rowErr) {
+					c.rowErrors = append(c.rowErrors, rowErr)
+					continue
+				}
+				yield(nil, err)
+				return
+			}
 
-	if _, err := c.csvFile.Seek(0, 0); err != nil {
-		return nil, err
+			trees++
+			batch = append(batch, tree)
+			if len(batch) == transformBatchSize {
+				if !yield(batch, nil) {
+					return
+				}
+				// the consumer may keep the batch
+				batch = make([]*entities.Tree, 0, transformBatchSize)
+			}
+		}
+		if len(batch) > 0 && !yield(batch, nil) {
+			return
+		}
+
+		if invalidRows := countInvalidRows(c.rowErrors); c.maxInvalidRows >= 0 && invalidRows > c.maxInvalidRows {
+			yield(nil, &ValidationError{
+				Errors:      c.rowErrors,
+				InvalidRows: invalidRows,
+				MaxInvalid:  c.maxInvalidRows,
+			})
+			return
+		}
+
+		elapsed := time.Since(start)
+		slog.Info("Imported trees from CSV", "elapsed", elapsed, "trees", trees, "errors", len(c.rowErrors))
 	}
+}
 
-	trees, err := c.mapCSVToTrees(ctx)
-	if err != nil {
-		return nil, err
+// Result describes the converted file without its trees. It is only complete
+// once all batches were read.
+func (c *CSVConverter) Result() *ConvertResult {
+	return &ConvertResult{
+		Errors:         c.rowErrors,
+		Dialect:        c.dialect,
+		ContentHash:    c.ContentHash(),
+		CRS:            c.CRS(),
+		Transformation: c.transformation,
 	}
+}
 
-	elapsed := time.Since(start)
-	slog.Info("Imported trees from CSV", "elapsed", elapsed)
+// InvalidRows returns the number of rows that were skipped as invalid.
+func (r *ConvertResult) InvalidRows() int {
+	return countInvalidRows(r.Errors)
+}
 
-	return trees, nil
+// Dialect returns the dialect detected from the input. It is only set once
+// iterating the trees has started.
+func (c *CSVConverter) Dialect() CSVDialect {
+	return c.dialect
 }
 
-func (c *CSVConverter) validateCsv() error {
-	if !c.isCsvFile() {
-		return errors.New("file is not a CSV file")
+// CRS returns how the source CRS was chosen. It is only complete once the
+// first trees were iterated.
+func (c *CSVConverter) CRS() *CRSDetection {
+	if c.crs == nil {
+		return &CRSDetection{Mode: c.crsDetection, Configured: c.fromEPSG, Used: c.fromEPSG}
 	}
+	return c.crs
+}
 
-	csvReader := csv.NewReader(c.csvFile)
-	headers, err := csvReader.Read()
-	if err != nil {
-		return err
+// ContentHash returns the hex encoded SHA-256 checksum of the normalized rows.
+// Every row is hashed with its trimmed values in a fixed column order, so two
+// exports of the same data have the same hash. It is only complete once all
+// trees were iterated.
+func (c *CSVConverter) ContentHash() string {
+	if c.contentHash == nil {
+		return ""
 	}
+	return hex.EncodeToString(c.contentHash.Sum(nil))
+}
 
-	if !c.hasExpectedHeaders(headers) {
-		return errors.New("csv file does not contain the expected headers")
-	}
+// Trees parses the input in a single pass and yields one tree per valid row.
+// Invalid rows are yielded as a *RowError and parsing continues with the next
+// row, any other error ends the sequence. Coordinates are transformed in
+// batches, so trees are yielded with a small delay compared to row errors.
+func (c *CSVConverter) Trees(ctx context.Context) iter.Seq2[*entities.Tree, error] {
+	return func(yield func(*entities.Tree, error) bool) {
+		r, err := c.newCSVReader()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
 
-	_, err = csvReader.ReadAll()
-	return err
-}
+		header, err := r.Read()
+		if err != nil {
+			slog.Error("Failed to read CSV", "error", err)
+			yield(nil, errors.Wrap(err, "failed to read CSV"))
+			return
+		}
+		header = slices.Clone(header) // the reader reuses the record slice
+
+		columnIndexes, err := c.columns.resolve(header)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		c.contentHash = sha256.New()
+
+		// the transformer is chosen with the first batch, as the source CRS may be
+		// detected from its coordinates
+		var transformer GeoTransformer
+
+		coordinateColumns := header[columnIndexes[FieldNorthing]] + "/" + header[columnIndexes[FieldEasting]]
+
+		batch := make([]*entities.Tree, 0, transformBatchSize)
+		lines := make([]int, 0, transformBatchSize)
+		flush := func() bool {
+			if transformer == nil && len(batch) > 0 {
+				if transformer, err = c.newTransformer(batch); err != nil {
+					yield(nil, err)
+					return false
+				}
+			}
+
+			invalid, err := c.transformTrees(transformer, batch)
+			if err != nil {
+				yield(nil, err)
+				return false
+			}
+			for i, tree := range batch {
+				if reason, ok := invalid[i]; ok {
+					if !yield(nil, &RowError{Row: lines[i], Column: coordinateColumns, Value: reason.value, Reason: reason.reason}) {
+						return false
+					}
+					continue
+				}
+				if !yield(tree, nil) {
+					return false
+				}
+			}
+			batch = batch[:0]
+			lines = lines[:0]
+			return true
+		}
+
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+
+			row, err := r.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				var parseErr *csv.ParseError
+				if errors.As(err, &parseErr) {
+					if !yield(nil, &RowError{Row: parseErr.Line, Reason: parseErr.Err.Error()}) {
+						return
+					}
+					continue
+				}
+				yield(nil, err)
+				return
+			}
+
+			c.hashRow(row, len(header), columnIndexes)
 
-func (c *CSVConverter) hasExpectedHeaders(headers []string) bool {
-	if len(headers) != len(c.expectedHeaders) {
-		return false
+			line, _ := r.FieldPos(0)
+			if len(row) != len(header) {
+				if !yield(nil, &RowError{Row: line, Reason: fmt.Sprintf("expected %d fields, got %d", len(header), len(row))}) {
+					return
+				}
+				continue
+			}
+
+			tree, rowErrors := c.parseRowToTree(line, row, header, columnIndexes)
+			if len(rowErrors) > 0 {
+				for _, rowErr := range rowErrors {
+					if !yield(nil, rowErr) {
+						return
+					}
+				}
+				continue
+			}
+
+			batch = append(batch, tree)
+			lines = append(lines, line)
+			if len(batch) == transformBatchSize && !flush() {
+				return
+			}
+		}
+
+		flush()
 	}
+}
 
-	for i, header := range headers {
-		if header != c.expectedHeaders[i] {
-			return false
+// hashRow adds the normalized row to the content hash. Rows with an unexpected
+// number of fields cannot be mapped to columns and are hashed as they are.
+func (c *CSVConverter) hashRow(row []string, fields int, columnIndexes map[CSVField]int) {
+	const unitSeparator, recordSeparator, groupSeparator = "\x1f", "\x1e", "\x1d"
+
+	if len(row) != fields {
+		io.WriteString(c.contentHash, groupSeparator)
+		for _, value := range row {
+			io.WriteString(c.contentHash, strings.TrimSpace(value)+unitSeparator)
 		}
+		io.WriteString(c.contentHash, recordSeparator)
+		return
 	}
 
-	return true
+	for _, field := range csvFields {
+		if idx, ok := columnIndexes[field]; ok {
+			io.WriteString(c.contentHash, strings.TrimSpace(row[idx]))
+		}
+		io.WriteString(c.contentHash, unitSeparator)
+	}
+	io.WriteString(c.contentHash, recordSeparator)
 }
 
-func (c *CSVConverter) isCsvFile() bool {
-	fileExt := strings.ToLower(filepath.Ext(c.csvFile.Name()))
-	slog.Debug("File extension", "ext", fileExt)
-	return fileExt == ".csv"
+// csvGeoPoint returns the untransformed position of a tree, which holds the
+// northing (Hochwert) in Latitude and the easting (Rechtswert) in Longitude.
+func csvGeoPoint(tree *entities.Tree) GeoPoint {
+	return GeoPoint{East: tree.Longitude, North: tree.Latitude}
 }
 
-func (c *CSVConverter) mapCSVToTrees(_ context.Context) ([]*entities.Tree, error) {
-	r := csv.NewReader(c.csvFile)
-	r.LazyQuotes = true
-	header, err := r.Read()
-	if err != nil {
-		slog.Error("Failed to read CSV", "error", err)
-		return nil, errors.Wrap(err, "failed to read CSV")
+// newTransformer chooses the source CRS, detecting it from the coordinates of
+// the first trees if configured, and returns the transformer for it.
+func (c *CSVConverter) newTransformer(trees []*entities.Tree) (GeoTransformer, error) {
+	c.crs = &CRSDetection{Mode: c.crsDetection, Configured: c.fromEPSG, Used: c.fromEPSG}
+
+	if detector := c.transformers.Detector(); c.crsDetection != CRSDetectionOff && detector != nil {
+		c.crs.Detected, c.crs.Scores = detector.Detect(utils.Map(trees, csvGeoPoint))
+		slog.Info("Detected source CRS", "configured", c.fromEPSG, "detected", c.crs.Detected, "sample", len(trees))
+
+		switch {
+		case c.crsDetection == CRSDetectionAuto && c.crs.Detected != 0:
+			c.crs.Used = c.crs.Detected
+		case c.crsDetection == CRSDetectionAuto && c.fromEPSG <= 0:
+			return nil, errors.Errorf("failed to detect the source CRS, no candidate puts %.0f%% of the coordinates into the area %s", minDetectionShare*100, c.boundingBox)
+		case c.crsDetection == CRSDetectionVerify && c.crs.Detected != 0 && c.crs.Detected != c.fromEPSG:
+			return nil, &CRSMismatchError{
+				Configured: c.fromEPSG,
+				Detected:   c.crs.Detected,
+				Scores:     c.crs.Scores,
+			}
+		}
 	}
 
-	headerIndexMap := c.createHeaderIndexMap(header)
-	transformer, err := NewGeoTransformer(c.fromEPSG, c.toEPSG)
+	transformer, err := c.transformers.Transformer(c.crs.Used)
 	if err != nil {
-		return nil, errors.Wrap(err, "error creating transformer")
+		return nil, err
 	}
 
-	var trees []*entities.Tree
-	for i := range utils.NumberSequence(1) {
-		row, err := r.Read()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return nil, err
-		}
-		tree, err := c.parseRowToTree(i, row, headerIndexMap)
-		if err != nil {
-			return nil, err
-		}
-		trees = append(trees, tree)
+	transformation := transformer.Transformation()
+	c.transformation = &transformation
+	if transformation.Accuracy != nil {
+		slog.Info("Transforming coordinates", "pipeline", transformation.Pipeline, "accuracy_m", *transformation.Accuracy)
+	} else {
+		slog.Warn("Transforming coordinates with unknown accuracy", "pipeline", transformation.Pipeline)
 	}
 
-	geoPoints := utils.Map(trees, func(tree *entities.Tree) GeoPoint {
-		return GeoPoint{X: tree.Latitude, Y: tree.Longitude}
-	})
+	return transformer, nil
+}
 
-	transformedPoints, err := transformer.TransformBatch(geoPoints)
-	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("failed to transform batch of points from EPSG %d to EPSG %d. err: %s", c.fromEPSG, c.toEPSG, err))
+// invalidPosition describes a tree whose position could not be transformed or
+// is outside the configured bounding box.
+type invalidPosition struct {
+	value  string
+	reason string
+}
+
+// transformTrees converts the CSV coordinates of the trees in place. Before
+// the transformation a tree holds the northing (Hochwert) in Latitude and the
+// easting (Rechtswert) in Longitude. Trees that cannot be transformed or end up
+// outside the bounding box are returned by their index.
+func (c *CSVConverter) transformTrees(transformer GeoTransformer, trees []*entities.Tree) (map[int]invalidPosition, error) {
+	if len(trees) == 0 {
+		return nil, nil
+	}
+
+	transformedPoints, err := transformer.TransformBatch(utils.Map(trees, csvGeoPoint))
+	var pointErrs *TransformErrors
+	if err != nil && !errors.As(err, &pointErrs) {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to transform batch of points from EPSG %d to EPSG %d. err: %s", c.crs.Used, c.toEPSG, err))
 	}
 
+	invalid := make(map[int]invalidPosition)
 	for i, tree := range trees {
-		tree.Latitude = transformedPoints[i].X
-		tree.Longitude = transformedPoints[i].Y
+		if pointErrs != nil && pointErrs.Points[i] != nil {
+			invalid[i] = invalidPosition{
+				value:  fmt.Sprintf("%v/%v", tree.Latitude, tree.Longitude),
+				reason: fmt.Sprintf("failed to transform the position from EPSG %d: %s", c.crs.Used, pointErrs.Points[i]),
+			}
+			continue
+		}
+
+		lat, lng := transformedPoints[i].North, transformedPoints[i].East
+		if c.boundingBox != nil && !c.boundingBox.Contains(lat, lng) {
+			invalid[i] = invalidPosition{
+				value:  fmt.Sprintf("%v/%v", tree.Latitude, tree.Longitude),
+				reason: fmt.Sprintf("position %.6f, %.6f is outside the configured area %s, check the source EPSG code and the column order", lat, lng, c.boundingBox),
+			}
+		}
+
+		tree.Latitude = lat
+		tree.Longitude = lng
 	}
 
-	return trees, nil
+	return invalid, nil
 }
 
-func (c *CSVConverter) createHeaderIndexMap(header []string) map[string]int {
-	headerIndexMap := make(map[string]int, len(header))
-	for i, h := range header {
-		headerIndexMap[h] = i
+// newCSVReader detects the dialect of the input and returns a reader for it
+// that always yields UTF-8.
+func (c *CSVConverter) newCSVReader() (*csv.Reader, error) {
+	reader, dialect, err := detectDialect(c.reader)
+	if err != nil {
+		return nil, err
 	}
-	return headerIndexMap
+	c.dialect = dialect
+	slog.Debug("Detected CSV dialect", "encoding", dialect.Encoding, "bom", dialect.HasBOM, "delimiter", string(dialect.Delimiter))
+
+	r := csv.NewReader(reader)
+	r.Comma = dialect.Delimiter
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	return r, nil
 }
 
-func (c *CSVConverter) parseRowToTree(rowIdx int, row []string, headerIndexMap map[string]int) (*entities.Tree, error) {
+// parseRowToTree validates every field of the row and returns all problems at
+// once instead of stopping at the first one.
+func (c *CSVConverter) parseRowToTree(rowIdx int, row, header []string, columnIndexes map[CSVField]int) (*entities.Tree, []*RowError) {
+	var rowErrors []*RowError
+
 	// Helper function for validating and retrieving a field from the row
-	getField := func(header string) (string, error) {
-		idx, exists := headerIndexMap[header]
-		if !exists || idx >= len(row) {
-			return "", errors.New(fmt.Sprintf("header '%s' not found or index out of bounds at row: %d", header, rowIdx))
+	getField := func(field CSVField, required bool) (string, bool) {
+		idx, exists := columnIndexes[field]
+		if !exists {
+			return "", !required
 		}
-		value := row[idx]
-		if value == "" {
-			return "", errors.New(fmt.Sprintf("invalid '%s' value at row: %d", header, rowIdx))
+		value := strings.TrimSpace(row[idx])
+		if value == "" && required {
+			rowErrors = append(rowErrors, &RowError{Row: rowIdx, Column: header[idx], Value: row[idx], Reason: "value is missing"})
+			return "", false
 		}
-		return value, nil
+		return value, true
 	}
 
-	parseFloat := func(value string, fieldName string) (float64, error) {
+	parseFloat := func(field CSVField) float64 {
+		value, ok := getField(field, true)
+		if !ok {
+			return 0
+		}
 		parsedValue, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", "."), 64)
 		if err != nil {
-			return 0, errors.Wrap(err, fmt.Sprintf("invalid '%s' value at row: %d", fieldName, rowIdx))
+			rowErrors = append(rowErrors, &RowError{Row: rowIdx, Column: header[columnIndexes[field]], Value: value, Reason: "not a valid decimal number"})
+			return 0
 		}
-		return parsedValue, nil
+		return parsedValue
 	}
 
-	parseInt := func(value string, fieldName string) (int, error) {
+	parseInt := func(field CSVField) int {
+		value, ok := getField(field, true)
+		if !ok {
+			return 0
+		}
 		parsedValue, err := strconv.Atoi(value)
 		if err != nil {
-			return 0, errors.Wrap(err, fmt.Sprintf("invalid '%s' value at row: %d", fieldName, rowIdx))
+			rowErrors = append(rowErrors, &RowError{Row: rowIdx, Column: header[columnIndexes[field]], Value: value, Reason: "not a valid integer"})
+			return 0
 		}
-		return parsedValue, nil
+		return parsedValue
 	}
 
-	area, err := getField(c.expectedHeaders[0])
-	if err != nil {
-		return nil, err
-	}
+	area, _ := getField(FieldArea, true)
+	street, _ := getField(FieldStreet, true)
+	treeNumber, _ := getField(FieldNumber, true)
+	species, _ := getField(FieldSpecies, false) // Default to empty string
+	latitude := parseFloat(FieldNorthing)
+	longitude := parseFloat(FieldEasting)
+	plantingYear := parseInt(FieldPlantingYear)
 
-	street, err := getField(c.expectedHeaders[1])
-	if err != nil {
-		return nil, err
-	}
-
-	treeNumber, err := getField(c.expectedHeaders[2])
-	if err != nil {
-		return nil, err
-	}
-
-	species, err := getField(c.expectedHeaders[3])
-	if err != nil {
-		species = "" // Default to empty string
-	}
-
-	latitudeStr, err := getField(c.expectedHeaders[4])
-	if err != nil {
-		return nil, err
-	}
-	latitude, err := parseFloat(latitudeStr, "Hochwert")
-	if err != nil {
-		return nil, err
-	}
-
-	longitudeStr, err := getField(c.expectedHeaders[5])
-	if err != nil {
-		return nil, err
-	}
-	longitude, err := parseFloat(longitudeStr, "Rechtswert")
-	if err != nil {
-		return nil, err
-	}
-
-	plantingYearStr, err := getField(c.expectedHeaders[6])
-	if err != nil {
-		return nil, err
-	}
-	plantingYear, err := parseInt(plantingYearStr, "Pflanzjahr")
-	if err != nil {
-		return nil, err
+	if len(rowErrors) > 0 {
+		return nil, rowErrors
 	}
 
 	tree := &entities.Tree{
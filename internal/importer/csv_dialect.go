@@ -0,0 +1,165 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	EncodingUTF8        = "UTF-8"
+	EncodingWindows1252 = "windows-1252"
+	EncodingISO88591    = "ISO-8859-1"
+)
+
+const (
+	dialectSniffSize  = 64 * 1024
+	dialectSniffLines = 5
+)
+
+var (
+	utf8BOM             = []byte{0xEF, 0xBB, 0xBF}
+	candidateDelimiters = []rune{',', ';', '\t'}
+)
+
+// CSVDialect describes how a CSV file was written. It is detected from the
+// beginning of the file because TBZ exports come out of Excel with varying
+// delimiters and legacy encodings.
+type CSVDialect struct {
+	Encoding  string
+	HasBOM    bool
+	Delimiter rune
+}
+
+// detectDialect sniffs encoding, byte order mark and delimiter from the start
+// of r. The returned reader yields the content as UTF-8 without the BOM.
+func detectDialect(r io.Reader) (io.Reader, CSVDialect, error) {
+	br := bufio.NewReaderSize(r, dialectSniffSize)
+	sample, err := br.Peek(dialectSniffSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, CSVDialect{}, errors.Wrap(err, "failed to read csv file")
+	}
+	truncated := len(sample) == dialectSniffSize
+
+	dialect := CSVDialect{Encoding: EncodingUTF8}
+	var reader io.Reader = br
+
+	switch {
+	case bytes.HasPrefix(sample, utf8BOM):
+		dialect.HasBOM = true
+		if _, err := br.Discard(len(utf8BOM)); err != nil {
+			return nil, CSVDialect{}, err
+		}
+		sample = sample[len(utf8BOM):]
+	case !isValidUTF8(sample, truncated):
+		var enc encoding.Encoding = charmap.ISO8859_1
+		dialect.Encoding = EncodingISO88591
+		if usesWindows1252(sample) {
+			enc = charmap.Windows1252
+			dialect.Encoding = EncodingWindows1252
+		}
+
+		reader = enc.NewDecoder().Reader(br)
+		sample, err = enc.NewDecoder().Bytes(sample)
+		if err != nil {
+			return nil, CSVDialect{}, errors.Wrap(err, "failed to decode csv file")
+		}
+	}
+
+	dialect.Delimiter = sniffDelimiter(sample, truncated)
+
+	return reader, dialect, nil
+}
+
+// isValidUTF8 reports whether sample is valid UTF-8. If the sample was cut off
+// from a longer input, an incomplete rune at its end is ignored.
+func isValidUTF8(sample []byte, truncated bool) bool {
+	if truncated {
+		for i := len(sample) - 1; i >= 0 && i >= len(sample)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(sample[i]) {
+				if !utf8.FullRune(sample[i:]) {
+					sample = sample[:i]
+				}
+				break
+			}
+		}
+	}
+	return utf8.Valid(sample)
+}
+
+// usesWindows1252 reports whether the sample contains bytes that are printable
+// characters in windows-1252 but control characters in ISO-8859-1.
+func usesWindows1252(sample []byte) bool {
+	for _, b := range sample {
+		if b >= 0x80 && b <= 0x9F {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffDelimiter picks the candidate delimiter that occurs the same number of
+// times outside of quotes in each of the first lines. Decimal commas in
+// semicolon separated files make the comma count vary between lines, so a
+// consistent count is preferred over a high one. Falls back to the candidate
+// that occurs most often in the header line and to a comma if none occurs.
+func sniffDelimiter(sample []byte, truncated bool) rune {
+	lines := bytes.Split(sample, []byte("\n"))
+	if truncated && len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+	}
+	lines = lines[:min(len(lines), dialectSniffLines)]
+
+	best, bestCount := ',', 0
+	for _, delimiter := range candidateDelimiters {
+		headerCount := countOutsideQuotes(lines[0], delimiter)
+		if headerCount > bestCount {
+			best, bestCount = delimiter, headerCount
+		}
+	}
+
+	consistent, consistentCount := rune(0), 0
+	for _, delimiter := range candidateDelimiters {
+		count := countOutsideQuotes(lines[0], delimiter)
+		if count == 0 {
+			continue
+		}
+
+		isConsistent := true
+		for _, line := range lines[1:] {
+			line = bytes.TrimRight(line, "\r")
+			if len(line) > 0 && countOutsideQuotes(line, delimiter) != count {
+				isConsistent = false
+				break
+			}
+		}
+
+		if isConsistent && count > consistentCount {
+			consistent, consistentCount = delimiter, count
+		}
+	}
+
+	if consistent != 0 {
+		return consistent
+	}
+	return best
+}
+
+func countOutsideQuotes(line []byte, delimiter rune) int {
+	count := 0
+	quoted := false
+	for _, r := range string(line) {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == delimiter && !quoted:
+			count++
+		}
+	}
+	return count
+}
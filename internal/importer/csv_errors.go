@@ -0,0 +1,41 @@
+package importer
+
+import (
+	"fmt"
+)
+
+// RowError describes a single problem with a value in the CSV file. Row is the
+// line number in the file, the header line being line 1.
+type RowError struct {
+	Row    int
+	Column string
+	Value  string
+	Reason string
+}
+
+func (e *RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("row %d: %s", e.Row, e.Reason)
+	}
+	return fmt.Sprintf("row %d, column '%s': %s (value: %q)", e.Row, e.Column, e.Reason, e.Value)
+}
+
+// ValidationError is returned when a CSV file contains more invalid rows than
+// the configured threshold allows. It carries every collected row error.
+type ValidationError struct {
+	Errors      []*RowError
+	InvalidRows int
+	MaxInvalid  int
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("csv file contains %d invalid rows (%d errors), at most %d are allowed", e.InvalidRows, len(e.Errors), e.MaxInvalid)
+}
+
+func countInvalidRows(rowErrors []*RowError) int {
+	rows := make(map[int]struct{}, len(rowErrors))
+	for _, rowErr := range rowErrors {
+		rows[rowErr.Row] = struct{}{}
+	}
+	return len(rows)
+}
@@ -0,0 +1,66 @@
+package importer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BoundingBox is an area in WGS84 coordinates. Converted trees have to lie
+// within it, which catches swapped axes or a wrong source CRS that would
+// otherwise silently put trees far outside the municipality.
+type BoundingBox struct {
+	MinLat float64
+	MinLng float64
+	MaxLat float64
+	MaxLng float64
+}
+
+// FlensburgBoundingBox covers the city of Flensburg with some margin.
+var FlensburgBoundingBox = BoundingBox{
+	MinLat: 54.70,
+	MinLng: 9.30,
+	MaxLat: 54.88,
+	MaxLng: 9.60,
+}
+
+func (b BoundingBox) Contains(lat, lng float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lng >= b.MinLng && lng <= b.MaxLng
+}
+
+func (b BoundingBox) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", b.MinLat, b.MinLng, b.MaxLat, b.MaxLng)
+}
+
+func (b BoundingBox) Validate() error {
+	if b.MinLat >= b.MaxLat || b.MinLng >= b.MaxLng {
+		return errors.Errorf("invalid bounding box %s, expected minimum before maximum", b)
+	}
+	if b.MinLat < -90 || b.MaxLat > 90 || b.MinLng < -180 || b.MaxLng > 180 {
+		return errors.Errorf("invalid bounding box %s, expected WGS84 coordinates", b)
+	}
+	return nil
+}
+
+// ParseBoundingBox parses a bounding box given as
+// "min_lat,min_lng,max_lat,max_lng".
+func ParseBoundingBox(s string) (BoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BoundingBox{}, errors.Errorf("invalid bounding box %q, expected min_lat,min_lng,max_lat,max_lng", s)
+	}
+
+	values := make([]float64, len(parts))
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return BoundingBox{}, errors.Errorf("invalid bounding box %q: %q is not a number", s, part)
+		}
+		values[i] = value
+	}
+
+	box := BoundingBox{MinLat: values[0], MinLng: values[1], MaxLat: values[2], MaxLng: values[3]}
+	return box, box.Validate()
+}
@@ -0,0 +1,148 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// geodeticDatum is the datum a coordinate reference system is based on.
+type geodeticDatum string
+
+const (
+	datumDHDN   geodeticDatum = "DHDN"
+	datumETRS89 geodeticDatum = "ETRS89"
+	datumWGS84  geodeticDatum = "WGS84"
+)
+
+// crsDatums lists the datum of the coordinate reference systems used for
+// tree registers in Germany.
+var crsDatums = map[int]geodeticDatum{
+	31466: datumDHDN, // Gauss-Krüger zone 2
+	31467: datumDHDN, // Gauss-Krüger zone 3
+	31468: datumDHDN, // Gauss-Krüger zone 4
+	31469: datumDHDN, // Gauss-Krüger zone 5
+	4314:  datumDHDN,
+	25832: datumETRS89, // ETRS89 / UTM zone 32N
+	25833: datumETRS89, // ETRS89 / UTM zone 33N
+	4258:  datumETRS89,
+	4326:  datumWGS84,
+	3857:  datumWGS84, // Web Mercator
+	32632: datumWGS84, // WGS84 / UTM zone 32N
+	32633: datumWGS84, // WGS84 / UTM zone 33N
+}
+
+// Documented accuracies of the transformation methods in metres. They are not
+// computed for the transformed coordinates. ETRS89 and WGS84 are treated as
+// identical, as PROJ and the backend do, the drift of less than a metre between
+// both is not included.
+const (
+	// accuracyConversion applies when no datum shift is needed.
+	accuracyConversion = 0.0
+	// accuracyNTv2 is documented for the BeTA2007 grid and the grids of the
+	// German states.
+	accuracyNTv2 = 0.1
+	// accuracyDHDNHelmert is the accuracy EPSG lists for the seven parameter
+	// transformation EPSG:1777.
+	accuracyDHDNHelmert = 3.0
+)
+
+// ntv2Magic starts the header of every NTv2 grid file.
+var ntv2Magic = []byte("NUM_OREC")
+
+// Transformation describes how coordinates are converted from one coordinate
+// reference system to another.
+type Transformation struct {
+	From    int
+	To      int
+	Backend TransformerBackend
+	// Pipeline describes the steps of the transformation.
+	Pipeline string
+	// GridFile is the NTv2 grid used for the datum shift, if any.
+	GridFile string
+	// Accuracy is the documented accuracy of the transformation method in
+	// metres, nil if it is unknown.
+	Accuracy *float64
+	// Assumed is set if PROJ chooses the operation between both systems itself.
+	// PROJ does not report which one it used, so Pipeline only names the
+	// systems and Accuracy is the one expected for the choice.
+	Assumed bool
+}
+
+// planTransformation chooses how coordinates are transformed from one EPSG
+// code to another. The grid file is only used for Gauss-Krüger sources and may
+// be empty.
+func planTransformation(backend TransformerBackend, from, to int, gridFile string) Transformation {
+	t := Transformation{
+		From:     from,
+		To:       to,
+		Backend:  backend,
+		Pipeline: fmt.Sprintf("EPSG:%d -> EPSG:%d", from, to),
+		Assumed:  backend == TransformerBackendPROJ,
+	}
+
+	fromDatum, fromKnown := crsDatums[from]
+	toDatum, toKnown := crsDatums[to]
+	if !fromKnown || !toKnown {
+		return t
+	}
+
+	definition, gaussKrueger := gaussKruegerDefinition(from, gridFile)
+	switch {
+	case fromDatum == datumDHDN && toDatum != datumDHDN && gridFile != "" && gaussKrueger:
+		// both backends apply exactly this definition
+		t.Pipeline = fmt.Sprintf("%s -> EPSG:%d", definition, to)
+		t.GridFile = gridFile
+		accuracy := accuracyNTv2
+		t.Accuracy = &accuracy
+		t.Assumed = false
+	case (fromDatum == datumDHDN) != (toDatum == datumDHDN):
+		if backend == TransformerBackendGo {
+			t.Pipeline = fmt.Sprintf("EPSG:%d -> EPSG:1777 -> EPSG:%d", from, to)
+			accuracy := accuracyDHDNHelmert
+			t.Accuracy = &accuracy
+		}
+		// PROJ may use a grid it finds or one of several Helmert
+		// transformations, so the accuracy is unknown
+	default:
+		accuracy := accuracyConversion
+		t.Accuracy = &accuracy
+	}
+
+	if t.Assumed {
+		t.Pipeline += " (operation chosen by PROJ)"
+	}
+
+	return t
+}
+
+// gaussKruegerDefinition returns the PROJ definition of a Gauss-Krüger zone
+// that shifts the DHDN datum with the given NTv2 grid.
+func gaussKruegerDefinition(epsg int, gridFile string) (string, bool) {
+	if epsg < 31466 || epsg > 31469 {
+		return "", false
+	}
+	zone := epsg - 31464
+	return fmt.Sprintf("+proj=tmerc +lat_0=0 +lon_0=%d +k=1 +x_0=%d +y_0=0 +ellps=bessel +nadgrids=%s +units=m +no_defs +type=crs",
+		3*zone, zone*1000000+500000, gridFile), true
+}
+
+// checkGridFile makes sure the grid file exists and is an NTv2 grid. PROJ
+// would otherwise only fail when the first coordinate is transformed.
+func checkGridFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open grid file")
+	}
+	defer f.Close()
+
+	header := make([]byte, len(ntv2Magic))
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header, ntv2Magic) {
+		return errors.Errorf("%s is not an NTv2 grid file", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package importer
+
+import "math"
+
+// ellipsoid is a reference ellipsoid given by its semi-major axis in metres
+// and its flattening.
+type ellipsoid struct {
+	a float64
+	f float64
+}
+
+var (
+	ellipsoidBessel1841 = ellipsoid{a: 6377397.155, f: 1 / 299.1528128}
+	ellipsoidGRS80      = ellipsoid{a: 6378137, f: 1 / 298.257222101}
+	ellipsoidWGS84      = ellipsoid{a: 6378137, f: 1 / 298.257223563}
+)
+
+// e2 returns the squared first eccentricity.
+func (e ellipsoid) e2() float64 {
+	return e.f * (2 - e.f)
+}
+
+// transverseMercator is a Transverse Mercator projection as used by the
+// Gauss-Krüger and UTM systems. It uses the series of Krüger to sixth order
+// in n, as described by Karney (2011), which is accurate to well below a
+// millimetre within a zone.
+type transverseMercator struct {
+	e          float64
+	k0         float64
+	lon0       float64
+	falseEast  float64
+	falseNorth float64
+	radius     float64 // rectifying radius A
+	alpha      [6]float64
+	beta       [6]float64
+}
+
+func newTransverseMercator(ell ellipsoid, k0, lon0Deg, falseEast float64) *transverseMercator {
+	n := ell.f / (2 - ell.f)
+	n2 := n * n
+	n3 := n2 * n
+	n4 := n3 * n
+	n5 := n4 * n
+	n6 := n5 * n
+
+	return &transverseMercator{
+		e:         math.Sqrt(ell.e2()),
+		k0:        k0,
+		lon0:      lon0Deg * math.Pi / 180,
+		falseEast: falseEast,
+		radius:    ell.a / (1 + n) * (1 + n2/4 + n4/64 + n6/256),
+		alpha: [6]float64{
+			n/2 - 2*n2/3 + 5*n3/16 + 41*n4/180 - 127*n5/288 + 7891*n6/37800,
+			13*n2/48 - 3*n3/5 + 557*n4/1440 + 281*n5/630 - 1983433*n6/1935360,
+			61*n3/240 - 103*n4/140 + 15061*n5/26880 + 167603*n6/181440,
+			49561*n4/161280 - 179*n5/168 + 6601661*n6/7257600,
+			34729*n5/80640 - 3418889*n6/1995840,
+			212378941 * n6 / 319334400,
+		},
+		beta: [6]float64{
+			n/2 - 2*n2/3 + 37*n3/96 - n4/360 - 81*n5/512 + 96199*n6/604800,
+			n2/48 + n3/15 - 437*n4/1440 + 46*n5/105 - 1118711*n6/3870720,
+			17*n3/480 - 37*n4/840 - 209*n5/4480 + 5569*n6/90720,
+			4397*n4/161280 - 11*n5/504 - 830251*n6/7257600,
+			4583*n5/161280 - 108847*n6/3991680,
+			20648693 * n6 / 638668800,
+		},
+	}
+}
+
+// conformal converts the tangent of the geodetic latitude to the tangent of
+// the conformal latitude.
+func (tm *transverseMercator) conformal(tau float64) float64 {
+	sigma := math.Sinh(tm.e * math.Atanh(tm.e*tau/math.Sqrt(1+tau*tau)))
+	return tau*math.Sqrt(1+sigma*sigma) - sigma*math.Sqrt(1+tau*tau)
+}
+
+// forward projects geographic coordinates in degrees to easting and northing.
+func (tm *transverseMercator) forward(lon, lat float64) (east, north float64) {
+	phi := lat * math.Pi / 180
+	lambda := lon*math.Pi/180 - tm.lon0
+
+	tauPrime := tm.conformal(math.Tan(phi))
+	xiPrime := math.Atan2(tauPrime, math.Cos(lambda))
+	etaPrime := math.Asinh(math.Sin(lambda) / math.Hypot(tauPrime, math.Cos(lambda)))
+
+	xi, eta := xiPrime, etaPrime
+	for j, alpha := range tm.alpha {
+		k := 2 * float64(j+1)
+		xi += alpha * math.Sin(k*xiPrime) * math.Cosh(k*etaPrime)
+		eta += alpha * math.Cos(k*xiPrime) * math.Sinh(k*etaPrime)
+	}
+
+	return tm.falseEast + tm.k0*tm.radius*eta, tm.falseNorth + tm.k0*tm.radius*xi
+}
+
+// inverse converts easting and northing to geographic coordinates in degrees.
+func (tm *transverseMercator) inverse(east, north float64) (lon, lat float64) {
+	xi := (north - tm.falseNorth) / (tm.k0 * tm.radius)
+	eta := (east - tm.falseEast) / (tm.k0 * tm.radius)
+
+	xiPrime, etaPrime := xi, eta
+	for j, beta := range tm.beta {
+		k := 2 * float64(j+1)
+		xiPrime -= beta * math.Sin(k*xi) * math.Cosh(k*eta)
+		etaPrime -= beta * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+
+	sinhEta := math.Sinh(etaPrime)
+	cosXi := math.Cos(xiPrime)
+	tauPrime := math.Sin(xiPrime) / math.Hypot(sinhEta, cosXi)
+
+	// Newton's method for the geodetic latitude of the conformal latitude
+	e2 := tm.e * tm.e
+	tau := tauPrime
+	for range 10 {
+		tauI := tm.conformal(tau)
+		delta := (tauPrime - tauI) / math.Sqrt(1+tauI*tauI) *
+			(1 + (1-e2)*tau*tau) / ((1 - e2) * math.Sqrt(1+tau*tau))
+		tau += delta
+		if math.Abs(delta) < 1e-12 {
+			break
+		}
+	}
+
+	lambda := math.Atan2(sinhEta, cosXi)
+	return (lambda + tm.lon0) * 180 / math.Pi, math.Atan(tau) * 180 / math.Pi
+}
+
+// webMercatorInverse converts Web Mercator coordinates to WGS84 degrees. Web
+// Mercator projects the WGS84 coordinates as if they were on a sphere.
+func webMercatorInverse(east, north float64) (lon, lat float64) {
+	return east / ellipsoidWGS84.a * 180 / math.Pi,
+		math.Atan(math.Sinh(north/ellipsoidWGS84.a)) * 180 / math.Pi
+}
+
+// helmert is a seven parameter transformation between two datums using the
+// position vector convention, as in the towgs84 parameter of PROJ.
+// Translations are in metres, rotations in arc seconds and the scale in ppm.
+type helmert struct {
+	tx, ty, tz float64
+	rx, ry, rz float64
+	scale      float64
+}
+
+// helmertDHDNToWGS84 are the parameters of EPSG:1777 (DHDN to WGS 84 (2)),
+// which PROJ uses for the Gauss-Krüger zones if no grid is available.
+var helmertDHDNToWGS84 = helmert{
+	tx: 598.1, ty: 73.7, tz: 418.2,
+	rx: 0.202, ry: 0.045, rz: -2.455,
+	scale: 6.7,
+}
+
+func (h helmert) apply(x, y, z float64) (float64, float64, float64) {
+	const arcSecond = math.Pi / (180 * 3600)
+	rx, ry, rz := h.rx*arcSecond, h.ry*arcSecond, h.rz*arcSecond
+	m := 1 + h.scale*1e-6
+
+	return h.tx + m*(x-rz*y+ry*z),
+		h.ty + m*(rz*x+y-rx*z),
+		h.tz + m*(-ry*x+rx*y+z)
+}
+
+// inverse returns the parameters of the reverse transformation. Negating the
+// parameters is exact to a few millimetres for the small rotations of
+// geodetic datums.
+func (h helmert) inverse() helmert {
+	return helmert{
+		tx: -h.tx, ty: -h.ty, tz: -h.tz,
+		rx: -h.rx, ry: -h.ry, rz: -h.rz,
+		scale: -h.scale,
+	}
+}
+
+// toGeocentric converts geographic coordinates in degrees on the ellipsoid
+// surface to geocentric cartesian coordinates.
+func (e ellipsoid) toGeocentric(lon, lat float64) (x, y, z float64) {
+	phi := lat * math.Pi / 180
+	lambda := lon * math.Pi / 180
+	e2 := e.e2()
+
+	sinPhi := math.Sin(phi)
+	n := e.a / math.Sqrt(1-e2*sinPhi*sinPhi)
+
+	return n * math.Cos(phi) * math.Cos(lambda),
+		n * math.Cos(phi) * math.Sin(lambda),
+		n * (1 - e2) * sinPhi
+}
+
+// fromGeocentric converts geocentric cartesian coordinates to geographic
+// coordinates in degrees, the ellipsoidal height is dropped.
+func (e ellipsoid) fromGeocentric(x, y, z float64) (lon, lat float64) {
+	e2 := e.e2()
+	p := math.Hypot(x, y)
+
+	phi := math.Atan2(z, p*(1-e2))
+	for range 10 {
+		sinPhi := math.Sin(phi)
+		n := e.a / math.Sqrt(1-e2*sinPhi*sinPhi)
+		h := p/math.Cos(phi) - n
+		next := math.Atan2(z, p*(1-e2*n/(n+h)))
+		if math.Abs(next-phi) < 1e-14 {
+			phi = next
+			break
+		}
+		phi = next
+	}
+
+	return math.Atan2(y, x) * 180 / math.Pi, phi * 180 / math.Pi
+}
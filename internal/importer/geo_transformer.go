@@ -1,66 +1,94 @@
 package importer
 
 import (
-	"github.com/green-ecolution/tbz-csv-import-plugin/internal/utils"
-	"github.com/omniscale/go-proj/v2"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
-type GeoTransformer struct {
-	from        *proj.Proj
-	to          *proj.Proj
-	transformer proj.Transformer
+// GeoTransformer converts coordinates from a source CRS to a geographic target
+// CRS. Coordinates are always given in east/north order, independent of the
+// axis order the EPSG registry defines for a CRS. Implementations are safe
+// for concurrent use.
+type GeoTransformer interface {
+	// Transform converts a position given as easting and northing (or
+	// longitude and latitude for geographic systems) of the source CRS.
+	Transform(east, north float64) (lat, lng float64, err error)
+	// TransformBatch converts the points from the source to the target CRS.
+	// The returned points have the longitude in East and the latitude in
+	// North. If only some points fail, the others are still returned together
+	// with a *TransformErrors.
+	TransformBatch(points []GeoPoint) ([]GeoPoint, error)
+	// Transformation describes the pipeline and the expected accuracy of the
+	// transformation.
+	Transformation() Transformation
 }
 
-func NewGeoTransformer(from, to int) (*GeoTransformer, error) {
-	fromProj, err := proj.NewEPSG(from)
-	if err != nil {
-		return nil, err
-	}
-
-	toProj, err := proj.NewEPSG(to)
-	if err != nil {
-		return nil, err
-	}
+// GeoPoint is a position with its east axis value (easting or longitude) and
+// north axis value (northing or latitude).
+type GeoPoint struct {
+	East  float64
+	North float64
+}
 
-	transformer, err := proj.NewEPSGTransformer(from, to)
-	if err != nil {
-		return nil, err
-	}
+// TransformErrors is returned by TransformBatch if some of the points could not
+// be transformed, for example because they are outside of the grid. Points maps
+// the index of each failed point to its error.
+type TransformErrors struct {
+	Points map[int]error
+}
 
-	return &GeoTransformer{
-		from:        fromProj,
-		to:          toProj,
-		transformer: transformer,
-	}, nil
+func (e *TransformErrors) Error() string {
+	return fmt.Sprintf("failed to transform %d points", len(e.Points))
 }
 
-func (g *GeoTransformer) Transform(x, y float64) (lat, lng float64, err error) {
-	points := []proj.Coord{
-		proj.XY(x, y),
+// add records the error of a point, the errors are only allocated once the
+// first point fails.
+func (e *TransformErrors) add(idx int, err error) {
+	if e.Points == nil {
+		e.Points = make(map[int]error)
 	}
+	e.Points[idx] = err
+}
 
-	if err := g.transformer.Transform(points); err != nil {
-		return 0, 0, err
+// orNil returns the errors if a point failed.
+func (e *TransformErrors) orNil() error {
+	if len(e.Points) == 0 {
+		return nil
 	}
-
-	return points[0].X, points[0].Y, nil
+	return e
 }
 
-type GeoPoint struct {
-	X float64
-	Y float64
-}
+// TransformerBackend selects the implementation of GeoTransformer.
+type TransformerBackend string
 
-func (g *GeoTransformer) TransformBatch(points []GeoPoint) ([]GeoPoint, error) {
-	coords := utils.Map(points, func(p GeoPoint) proj.Coord {
-		return proj.XY(p.X, p.Y)
-	})
+const (
+	// TransformerBackendPROJ uses the PROJ library, it needs cgo.
+	TransformerBackendPROJ TransformerBackend = "proj"
+	// TransformerBackendGo is implemented in Go and supports the coordinate
+	// reference systems listed in crsDatums.
+	TransformerBackendGo TransformerBackend = "go"
+)
 
-	if err := g.transformer.Transform(coords); err != nil {
-		return nil, err
+func ParseTransformerBackend(s string) (TransformerBackend, error) {
+	switch backend := TransformerBackend(strings.ToLower(strings.TrimSpace(s))); backend {
+	case TransformerBackendPROJ, TransformerBackendGo:
+		return backend, nil
+	default:
+		return "", errors.Errorf("unknown transformer %q, expected %q or %q", s, TransformerBackendPROJ, TransformerBackendGo)
 	}
+}
 
-	return utils.Map(coords, func(c proj.Coord) GeoPoint {
-		return GeoPoint{X: c.X, Y: c.Y}
-	}), nil
+// NewGeoTransformer creates a transformer from one EPSG code to another. The
+// NTv2 grid file is used for DHDN based sources and may be empty.
+func NewGeoTransformer(backend TransformerBackend, from, to int, gridFile string) (GeoTransformer, error) {
+	switch backend {
+	case TransformerBackendPROJ:
+		return newProjTransformer(from, to, gridFile)
+	case TransformerBackendGo:
+		return newGoTransformer(from, to, gridFile)
+	default:
+		return nil, errors.Errorf("unknown transformer %q", backend)
+	}
 }
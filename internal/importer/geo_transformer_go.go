@@ -0,0 +1,133 @@
+package importer
+
+import (
+	"github.com/pkg/errors"
+)
+
+// goCRS is a coordinate reference system supported by the Go transformer.
+type goCRS struct {
+	datum     geodeticDatum
+	ellipsoid ellipsoid
+	// inverse converts the coordinates to longitude and latitude on the
+	// datum, it is nil for geographic systems.
+	inverse func(east, north float64) (lon, lat float64)
+}
+
+func (c goCRS) geographic() bool {
+	return c.inverse == nil
+}
+
+// newGoCRS returns the definition of one of the systems listed in crsDatums.
+func newGoCRS(epsg int) (goCRS, bool) {
+	switch epsg {
+	case 31466, 31467, 31468, 31469:
+		zone := epsg - 31464
+		tm := newTransverseMercator(ellipsoidBessel1841, 1, float64(3*zone), float64(zone*1000000+500000))
+		return goCRS{datum: datumDHDN, ellipsoid: ellipsoidBessel1841, inverse: tm.inverse}, true
+	case 4314:
+		return goCRS{datum: datumDHDN, ellipsoid: ellipsoidBessel1841}, true
+	case 25832, 25833:
+		tm := newTransverseMercator(ellipsoidGRS80, 0.9996, float64(6*(epsg-25800)-183), 500000)
+		return goCRS{datum: datumETRS89, ellipsoid: ellipsoidGRS80, inverse: tm.inverse}, true
+	case 4258:
+		return goCRS{datum: datumETRS89, ellipsoid: ellipsoidGRS80}, true
+	case 32632, 32633:
+		tm := newTransverseMercator(ellipsoidWGS84, 0.9996, float64(6*(epsg-32600)-183), 500000)
+		return goCRS{datum: datumWGS84, ellipsoid: ellipsoidWGS84, inverse: tm.inverse}, true
+	case 4326:
+		return goCRS{datum: datumWGS84, ellipsoid: ellipsoidWGS84}, true
+	case 3857:
+		return goCRS{datum: datumWGS84, ellipsoid: ellipsoidWGS84, inverse: webMercatorInverse}, true
+	default:
+		return goCRS{}, false
+	}
+}
+
+// goTransformer converts coordinates without PROJ. It supports the systems
+// used for tree registers in Germany, ETRS89 and WGS84 are treated as
+// identical like PROJ does. DHDN is shifted with the NTv2 grid if one is
+// given and with the Helmert transformation PROJ falls back to otherwise.
+type goTransformer struct {
+	from           goCRS
+	to             goCRS
+	grid           *ntv2Grid
+	helmert        *helmert
+	transformation Transformation
+}
+
+func newGoTransformer(from, to int, gridFile string) (*goTransformer, error) {
+	fromCRS, ok := newGoCRS(from)
+	if !ok {
+		return nil, errors.Errorf("EPSG %d is not supported by the %q transformer", from, TransformerBackendGo)
+	}
+
+	toCRS, ok := newGoCRS(to)
+	if !ok {
+		return nil, errors.Errorf("EPSG %d is not supported by the %q transformer", to, TransformerBackendGo)
+	}
+
+	if !toCRS.geographic() {
+		return nil, errors.Errorf("EPSG %d is not a geographic coordinate reference system, trees are stored with latitude and longitude", to)
+	}
+
+	transformation := planTransformation(TransformerBackendGo, from, to, gridFile)
+
+	g := &goTransformer{
+		from:           fromCRS,
+		to:             toCRS,
+		transformation: transformation,
+	}
+
+	switch {
+	case transformation.GridFile != "":
+		grid, err := loadNTv2Grid(transformation.GridFile)
+		if err != nil {
+			return nil, err
+		}
+		g.grid = grid
+	case fromCRS.datum == datumDHDN && toCRS.datum != datumDHDN:
+		g.helmert = &helmertDHDNToWGS84
+	case fromCRS.datum != datumDHDN && toCRS.datum == datumDHDN:
+		inverse := helmertDHDNToWGS84.inverse()
+		g.helmert = &inverse
+	}
+
+	return g, nil
+}
+
+func (g *goTransformer) Transformation() Transformation {
+	return g.transformation
+}
+
+func (g *goTransformer) Transform(east, north float64) (lat, lng float64, err error) {
+	lng, lat = east, north
+	if !g.from.geographic() {
+		lng, lat = g.from.inverse(east, north)
+	}
+
+	switch {
+	case g.grid != nil:
+		var ok bool
+		if lng, lat, ok = g.grid.shift(lng, lat); !ok {
+			return 0, 0, errors.Errorf("point %f, %f is outside of the grid %s", east, north, g.transformation.GridFile)
+		}
+	case g.helmert != nil:
+		lng, lat = g.to.ellipsoid.fromGeocentric(g.helmert.apply(g.from.ellipsoid.toGeocentric(lng, lat)))
+	}
+
+	return lat, lng, nil
+}
+
+func (g *goTransformer) TransformBatch(points []GeoPoint) ([]GeoPoint, error) {
+	transformed := make([]GeoPoint, len(points))
+	var errs TransformErrors
+	for i, p := range points {
+		lat, lng, err := g.Transform(p.East, p.North)
+		if err != nil {
+			errs.add(i, err)
+			continue
+		}
+		transformed[i] = GeoPoint{East: lng, North: lat}
+	}
+	return transformed, errs.orNil()
+}
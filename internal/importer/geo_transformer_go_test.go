@@ -0,0 +1,81 @@
+package importer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestGoTransformerGoldenValues(t *testing.T) {
+	// the expected positions were computed independently with the inverse
+	// Transverse Mercator series of Snyder (1987), the Gauss-Krüger zones are
+	// shifted with the parameters of EPSG:1777 as without a grid
+	tests := []struct {
+		from        int
+		east, north float64
+		lat, lng    float64
+	}{
+		{from: 31467, east: 3530000, north: 6070000, lat: 54.758448163, lng: 9.464914746},
+		{from: 31467, east: 3570000, north: 5930000, lat: 53.496912618, lng: 10.053736696},
+		{from: 31468, east: 4468000, north: 5428000, lat: 48.988505771, lng: 11.561311952},
+		{from: 25832, east: 530000, north: 6070000, lat: 54.776313340, lng: 9.466391272},
+		{from: 25832, east: 690000, north: 5335000, lat: 48.139857732, lng: 11.554072706},
+		{from: 25833, east: 390000, north: 5820000, lat: 52.518995312, lng: 13.378811770},
+		{from: 3857, east: 1050000, north: 7310000, lat: 54.731867486, lng: 9.432310483},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("EPSG %d %.0f %.0f", tt.from, tt.east, tt.north), func(t *testing.T) {
+			transformer, err := newGoTransformer(tt.from, 4326, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lat, lng, err := transformer.Transform(tt.east, tt.north)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if distance := Distance(lat, lng, tt.lat, tt.lng); distance > 0.01 {
+				t.Errorf("got %.9f, %.9f, %.3f m from %.9f, %.9f", lat, lng, distance, tt.lat, tt.lng)
+			}
+		})
+	}
+}
+
+func TestGoTransformerBatchOutsideGrid(t *testing.T) {
+	// the grid only covers Schleswig-Holstein
+	grid := writeNTv2Grid(t, buildNTv2Grid(binary.LittleEndian, []testSubgrid{
+		{name: "SH", parent: "NONE", south: 53, north: 55.5, west: 7.5, east: 11.5, inc: 0.5, shift: constantShift(1, 2)},
+	}))
+
+	transformer, err := newGoTransformer(31467, 4326, grid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	points := []GeoPoint{
+		{East: 3530000, North: 6070000}, // Flensburg
+		{East: 3690000, North: 5335000}, // Munich, zone 3 coordinates
+		{East: 3570000, North: 5930000}, // Kiel
+	}
+	transformed, err := transformer.TransformBatch(points)
+
+	var pointErrs *TransformErrors
+	if !errors.As(err, &pointErrs) {
+		t.Fatalf("expected *TransformErrors, got %v", err)
+	}
+	if len(pointErrs.Points) != 1 || pointErrs.Points[1] == nil {
+		t.Fatalf("expected only the second point to fail, got %v", pointErrs.Points)
+	}
+
+	for _, i := range []int{0, 2} {
+		lat, lng, err := transformer.Transform(points[i].East, points[i].North)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transformed[i].North != lat || transformed[i].East != lng {
+			t.Errorf("point %d: got %v, expected %v, %v", i, transformed[i], lng, lat)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+//go:build cgo
+
+package importer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/utils"
+	"github.com/omniscale/go-proj/v2"
+	"github.com/pkg/errors"
+)
+
+// DefaultTransformerBackend is PROJ if the plugin is built with cgo.
+const DefaultTransformerBackend = TransformerBackendPROJ
+
+// projTransformer converts coordinates with PROJ.
+//
+// PROJ uses the axis order defined by the EPSG registry, which differs between
+// systems: EPSG:31467 lists northing before easting and EPSG:4326 latitude
+// before longitude, while EPSG:25832 lists easting first. The transformer is
+// normalized to the east/north order, so callers never depend on the axis
+// order of a specific CRS.
+//
+// DHDN based Gauss-Krüger coordinates are shifted with the NTv2 grid file if
+// one is given, which is read from disk and never downloaded.
+//
+// A PROJ context must not be used by two threads at once, so transformations
+// are serialized.
+type projTransformer struct {
+	mu             sync.Mutex
+	from           *proj.Proj
+	to             *proj.Proj
+	transformer    proj.Transformer
+	transformation Transformation
+}
+
+func newProjTransformer(from, to int, gridFile string) (*projTransformer, error) {
+	transformation := planTransformation(TransformerBackendPROJ, from, to, gridFile)
+
+	fromDefinition := fmt.Sprintf("epsg:%d", from)
+	if transformation.GridFile != "" {
+		definition, ok := gaussKruegerDefinition(from, transformation.GridFile)
+		if !ok {
+			return nil, errors.Errorf("EPSG %d is not a Gauss-Krüger zone, the grid file cannot be applied", from)
+		}
+		fromDefinition = definition
+	}
+
+	fromProj, err := proj.New(fromDefinition)
+	if err != nil {
+		return nil, err
+	}
+
+	toProj, err := proj.NewEPSG(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if !toProj.IsLatLong() {
+		return nil, errors.Errorf("EPSG %d is not a geographic coordinate reference system, trees are stored with latitude and longitude", to)
+	}
+
+	transformer, err := proj.NewTransformer(fromDefinition, fmt.Sprintf("epsg:%d", to))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := transformer.NormalizeForVisualization(); err != nil {
+		return nil, errors.Wrap(err, "failed to normalize axis order")
+	}
+
+	return &projTransformer{
+		from:           fromProj,
+		to:             toProj,
+		transformer:    transformer,
+		transformation: transformation,
+	}, nil
+}
+
+func (g *projTransformer) Transformation() Transformation {
+	return g.transformation
+}
+
+func (g *projTransformer) Transform(east, north float64) (lat, lng float64, err error) {
+	points := []proj.Coord{
+		proj.XY(east, north),
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.transformer.Transform(points); err != nil {
+		return 0, 0, err
+	}
+
+	return points[0].Y, points[0].X, nil
+}
+
+func (g *projTransformer) TransformBatch(points []GeoPoint) ([]GeoPoint, error) {
+	coords := utils.Map(points, func(p GeoPoint) proj.Coord {
+		return proj.XY(p.East, p.North)
+	})
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.transformer.Transform(coords); err != nil {
+		// PROJ fails the whole batch if one point fails, the points are
+		// transformed one by one to find out which
+		return g.transformEach(points)
+	}
+
+	return utils.Map(coords, func(c proj.Coord) GeoPoint {
+		return GeoPoint{East: c.X, North: c.Y}
+	}), nil
+}
+
+func (g *projTransformer) transformEach(points []GeoPoint) ([]GeoPoint, error) {
+	transformed := make([]GeoPoint, len(points))
+	var errs TransformErrors
+	for i, p := range points {
+		coords := []proj.Coord{proj.XY(p.East, p.North)}
+		if err := g.transformer.Transform(coords); err != nil {
+			errs.add(i, err)
+			continue
+		}
+		transformed[i] = GeoPoint{East: coords[0].X, North: coords[0].Y}
+	}
+	return transformed, errs.orNil()
+}
@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package importer
+
+import "github.com/pkg/errors"
+
+// DefaultTransformerBackend is the Go implementation if the plugin is built
+// without cgo, as PROJ is not available then.
+const DefaultTransformerBackend = TransformerBackendGo
+
+func newProjTransformer(from, to int, gridFile string) (GeoTransformer, error) {
+	return nil, errors.Errorf("the PROJ transformer is not available in builds without cgo, use %q instead", TransformerBackendGo)
+}
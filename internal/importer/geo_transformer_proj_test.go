@@ -0,0 +1,109 @@
+//go:build cgo
+
+package importer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/omniscale/go-proj/v2"
+)
+
+// TestGoTransformerMatchesPROJ compares the pure Go transformer with PROJ.
+func TestGoTransformerMatchesPROJ(t *testing.T) {
+	// a grid over Germany with shifts of a few arc seconds that change from
+	// node to node, so the interpolation and the sign of the shifts matter
+	grid := writeNTv2Grid(t, buildNTv2Grid(binary.LittleEndian, []testSubgrid{
+		{name: "DE", parent: "NONE", south: 47, north: 56, west: 5, east: 16, inc: 0.5, shift: func(row, col int) (float32, float32) {
+			return 0.1*float32(row) - 0.5, 4 + 0.05*float32(col)
+		}},
+	}))
+
+	points := map[int][]GeoPoint{
+		31466: {{East: 2550000, North: 5650000}, {East: 2590000, North: 5720000}},
+		31467: {{East: 3530000, North: 6070000}, {East: 3570000, North: 5930000}},
+		31468: {{East: 4468000, North: 5428000}, {East: 4500000, North: 5600000}},
+		31469: {{East: 5400000, North: 5820000}, {East: 5450000, North: 5700000}},
+		25832: {{East: 530000, North: 6070000}, {East: 690000, North: 5335000}},
+		25833: {{East: 390000, North: 5820000}, {East: 410000, North: 5660000}},
+		3857:  {{East: 1050000, North: 7310000}, {East: 1290000, North: 6130000}},
+		4326:  {{East: 9.43, North: 54.78}, {East: 11.57, North: 48.14}},
+	}
+
+	tests := []struct {
+		from     int
+		gridFile string
+		// tolerance in metres
+		tolerance float64
+	}{
+		{from: 25832, tolerance: 0.01},
+		{from: 25833, tolerance: 0.01},
+		{from: 3857, tolerance: 0.01},
+		{from: 4326, tolerance: 0.01},
+		{from: 31466, gridFile: grid, tolerance: 0.01},
+		{from: 31467, gridFile: grid, tolerance: 0.01},
+		{from: 31468, gridFile: grid, tolerance: 0.01},
+		{from: 31469, gridFile: grid, tolerance: 0.01},
+		// without a grid PROJ is pinned to the EPSG:1777 parameters the Go
+		// transformer uses, see newPinnedPROJTransformer
+		{from: 31466, tolerance: 0.01},
+		{from: 31467, tolerance: 0.01},
+		{from: 31468, tolerance: 0.01},
+		{from: 31469, tolerance: 0.01},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("EPSG %d grid %t", tt.from, tt.gridFile != ""), func(t *testing.T) {
+			goTransformer, err := newGoTransformer(tt.from, 4326, tt.gridFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			projTransformer, err := newProjTransformer(tt.from, 4326, tt.gridFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := gaussKruegerDefinition(tt.from, ""); ok && tt.gridFile == "" {
+				projTransformer = newPinnedPROJTransformer(t, tt.from)
+			}
+
+			fromGo, err := goTransformer.TransformBatch(points[tt.from])
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromPROJ, err := projTransformer.TransformBatch(points[tt.from])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range points[tt.from] {
+				distance := Distance(fromGo[i].North, fromGo[i].East, fromPROJ[i].North, fromPROJ[i].East)
+				if distance > tt.tolerance {
+					t.Errorf("point %v: Go %v and PROJ %v are %.3f m apart", points[tt.from][i], fromGo[i], fromPROJ[i], distance)
+				}
+			}
+		})
+	}
+}
+
+// newPinnedPROJTransformer returns a PROJ transformer from a Gauss-Krüger zone
+// to EPSG:4326 that shifts the datum with the parameters of EPSG:1777. Without
+// a grid PROJ would otherwise choose the operation itself, which depends on the
+// installed PROJ version and database.
+func newPinnedPROJTransformer(t *testing.T, from int) *projTransformer {
+	t.Helper()
+
+	h := helmertDHDNToWGS84
+	zone := from - 31464
+	definition := fmt.Sprintf("+proj=tmerc +lat_0=0 +lon_0=%d +k=1 +x_0=%d +y_0=0 +ellps=bessel +towgs84=%g,%g,%g,%g,%g,%g,%g +units=m +no_defs +type=crs",
+		3*zone, zone*1000000+500000, h.tx, h.ty, h.tz, h.rx, h.ry, h.rz, h.scale)
+
+	transformer, err := proj.NewTransformer(definition, "epsg:4326")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transformer.NormalizeForVisualization(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &projTransformer{transformer: transformer}
+}
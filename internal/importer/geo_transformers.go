@@ -0,0 +1,70 @@
+package importer
+
+import (
+	"github.com/pkg/errors"
+)
+
+// GeoTransformers holds the transformers for the configured source CRS and the
+// detection candidates. Creating a transformer is expensive, the Go
+// implementation for example reads the NTv2 grid from disk, so they are
+// created once at startup and shared by all conversions.
+type GeoTransformers struct {
+	to           int
+	transformers map[int]GeoTransformer
+	detector     *CRSDetector
+}
+
+// NewGeoTransformers creates the transformers the validated configuration
+// needs. It fails if coordinates cannot be transformed from one of the
+// configured systems.
+func NewGeoTransformers(cfg ConverterConfig) (*GeoTransformers, error) {
+	detect := cfg.CRSDetection != CRSDetectionOff && cfg.BoundingBox != nil
+
+	sources := make([]int, 0, len(cfg.CRSCandidates)+1)
+	if cfg.FromEPSG > 0 {
+		sources = append(sources, cfg.FromEPSG)
+	}
+	if detect {
+		sources = append(sources, cfg.CRSCandidates...)
+	}
+
+	t := &GeoTransformers{
+		to:           cfg.ToEPSG,
+		transformers: make(map[int]GeoTransformer, len(sources)),
+	}
+	for _, from := range sources {
+		if _, ok := t.transformers[from]; ok {
+			continue
+		}
+
+		transformer, err := NewGeoTransformer(cfg.Transformer, from, cfg.ToEPSG, cfg.GridFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot transform coordinates from EPSG %d to EPSG %d", from, cfg.ToEPSG)
+		}
+		t.transformers[from] = transformer
+	}
+
+	if detect {
+		detector, err := NewCRSDetector(cfg.CRSCandidates, t.transformers, *cfg.BoundingBox)
+		if err != nil {
+			return nil, err
+		}
+		t.detector = detector
+	}
+
+	return t, nil
+}
+
+// Transformer returns the transformer from the given source CRS.
+func (t *GeoTransformers) Transformer(from int) (GeoTransformer, error) {
+	transformer, ok := t.transformers[from]
+	if !ok {
+		return nil, errors.Errorf("coordinates from EPSG %d cannot be transformed, it is neither configured nor a detection candidate", from)
+	}
+	return transformer, nil
+}
+
+// Detector returns the CRS detector, nil if detection is disabled.
+func (t *GeoTransformers) Detector() *CRSDetector {
+	return t.detector
+}
@@ -0,0 +1,118 @@
+package importer
+
+import (
+	"context"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+)
+
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 500
+)
+
+// ImportPage is one page of the import history.
+type ImportPage struct {
+	Imports []entities.ImportSummary
+	Total   int
+	Limit   int
+	Offset  int
+}
+
+// ImportDetails is an import together with the trees it touched.
+type ImportDetails struct {
+	Summary entities.ImportSummary
+	Changes []ImportChange
+}
+
+// ImportChange is a single operation of an import. Tree is the state that was
+// sent to the backend and is not set for deletes, Previous is the state before
+// an update or delete.
+type ImportChange struct {
+	OperationID entities.ImportOperationID
+	Operation   entities.OperationType
+	Status      entities.OperationStatus
+	Attempts    int32
+	Tree        *entities.Tree
+	Previous    *entities.Tree
+	// Diff lists the attributes an update changed.
+	Diff  []entities.FieldChange
+	Error *string
+}
+
+// ListImports returns the imports matching the filter, newest first. The limit
+// defaults to DefaultHistoryLimit and is capped at MaxHistoryLimit.
+func (i *ImportService) ListImports(ctx context.Context, filter entities.ImportFilter) (*ImportPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultHistoryLimit
+	}
+	filter.Limit = min(filter.Limit, MaxHistoryLimit)
+	filter.Offset = max(filter.Offset, 0)
+
+	imports, total, err := i.importRepo.ListImports(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImportPage{
+		Imports: imports,
+		Total:   total,
+		Limit:   filter.Limit,
+		Offset:  filter.Offset,
+	}, nil
+}
+
+// GetImportDetails returns the import with all of its operations in the order
+// they were planned.
+func (i *ImportService) GetImportDetails(ctx context.Context, importID entities.ImportID) (*ImportDetails, error) {
+	summary, err := i.importRepo.GetImportSummary(ctx, importID)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := i.importRepo.GetOperations(ctx, importID)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]ImportChange, 0, len(ops))
+	for _, op := range ops {
+		change := ImportChange{
+			OperationID: op.ID,
+			Operation:   op.Operation,
+			Status:      op.Status,
+			Attempts:    op.Attempts,
+			Error:       op.LastError,
+		}
+
+		if op.Payload != nil {
+			if change.Tree, err = decodeTree(op.ID, op.Payload); err != nil {
+				return nil, err
+			}
+			// the payload of a create was written before the ids were known
+			if op.TreeID != nil {
+				change.Tree.TreeID = *op.TreeID
+			}
+			if op.BackendID != nil {
+				change.Tree.BackendID = op.BackendID
+			}
+		}
+
+		if op.Previous != nil {
+			if change.Previous, err = decodePrevious(&op); err != nil {
+				return nil, err
+			}
+		}
+
+		if change.Diff, err = decodeDiff(&op); err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	return &ImportDetails{
+		Summary: *summary,
+		Changes: changes,
+	}, nil
+}
@@ -2,96 +2,355 @@ package importer
 
 import (
 	"context"
-	"slices"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
 	"github.com/green-ecolution/tbz-csv-import-plugin/internal/importer/storage"
+	"github.com/pkg/errors"
 )
 
 type ImportService struct {
 	importRepo *storage.ImportRepositoryDB
 	clientRepo *storage.GreenEcolutionRepo
+	cfg        ImportConfig
+	mu         sync.Mutex
+	// storeMu serializes the local writes of concurrent workers, SQLite only
+	// allows one writer at a time.
+	storeMu sync.Mutex
 }
 
-func NewImportService() *ImportService {
-	return &ImportService{}
+type ImportResult struct {
+	ImportID entities.ImportID
+	Status   entities.ImportStatus
+	Mode     SyncMode
+	Created  []*entities.Tree
+	Updated  []*entities.Tree
+	Deleted  []entities.TreeID
+	// Unlinked contains previously imported trees that should have been updated
+	// or deleted but have no known id in the Green Ecolution backend. They are
+	// left untouched.
+	Unlinked  []*entities.Tree
+	Ambiguous []AmbiguousMatch
+	// Unchanged contains the trees that did not differ from the previously
+	// imported ones and were skipped.
+	Unchanged []*entities.Tree
+	// Errors contains one entry per operation that failed.
+	Errors []*TreeError
+	Stats  ImportStats
+	// DuplicateOf is set when the content was already imported and nothing
+	// was done.
+	DuplicateOf *entities.ImportID
 }
 
-func (i *ImportService) Import(ctx context.Context, trees []*entities.Tree) error {
-	deleteQueue := make([]entities.TreeID, len(trees))
-	createQueue := make([]*entities.Tree, len(trees))
-	updateQueue := make([]*entities.Tree, len(trees))
+// ImportStats describes the backend operations run by an import.
+type ImportStats struct {
+	Operations int
+	Applied    int
+	Failed     int
+	Duration   time.Duration
+}
+
+// Throughput returns the number of operations run per second.
+func (s ImportStats) Throughput() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Operations) / s.Duration.Seconds()
+}
+
+// TreeError is the error of a single operation of an import.
+type TreeError struct {
+	OperationID entities.ImportOperationID
+	Operation   entities.OperationType
+	Tree        *entities.Tree
+	Err         error
+}
+
+func (e *TreeError) Error() string {
+	return fmt.Sprintf("operation %d (%s): %v", e.OperationID, e.Operation, e.Err)
+}
+
+func (e *TreeError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyError is returned when operations of an import failed. The other
+// operations were still applied.
+type ApplyError struct {
+	Errors []*TreeError
+}
+
+func (e *ApplyError) Error() string {
+	return fmt.Sprintf("%d operations failed, first error: %v", len(e.Errors), e.Errors[0])
+}
+
+// ImportFailedError is returned when a backend or database call failed while
+// applying an import. Status tells whether the already applied operations were
+// rolled back or are still in place.
+type ImportFailedError struct {
+	ImportID entities.ImportID
+	Status   entities.ImportStatus
+	Err      error
+}
+
+func (e *ImportFailedError) Error() string {
+	return fmt.Sprintf("import %d failed (status: %s): %v", e.ImportID, e.Status, e.Err)
+}
+
+func (e *ImportFailedError) Unwrap() error {
+	return e.Err
+}
 
-	allImportedTrees, err := i.importRepo.GetAllTrees(ctx)
+func NewImportService(importRepo *storage.ImportRepositoryDB, clientRepo *storage.GreenEcolutionRepo, cfg ImportConfig) *ImportService {
+	return &ImportService{
+		importRepo: importRepo,
+		clientRepo: clientRepo,
+		cfg:        cfg,
+	}
+}
+
+// Import applies the changes computed by Plan. If the plan deletes more trees
+// than the configured limit and the deletions were not confirmed, nothing is
+// written and a *TooManyDeletionsError is returned.
+//
+// Every tree is first written to the backend and only stored locally once the
+// backend confirmed the change. Trees are written concurrently and transient
+// backend errors are retried. If operations still fail, the import is either
+// compensated or left partially applied, depending on the configuration. In
+// that case the result is returned together with an *ImportFailedError.
+//
+// All changes are written to the import journal before the first backend call,
+// so an interrupted import can be continued with Resume.
+//
+// Unless forced, content that was already imported successfully is rejected
+// with a *DuplicateImportError or skipped, depending on the configuration.
+func (i *ImportService) Import(ctx context.Context, source TreeSource, opts ImportOptions) (*ImportResult, error) {
+	// imports read and write the whole tree table, running two at once would corrupt it
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	plan, err := i.Plan(ctx, source, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	converted := source.Result()
 
-	for _, csvTree := range trees {
-		idx := slices.IndexFunc(allImportedTrees, func(tree entities.Tree) bool {
-			return tree.Latitude == csvTree.Latitude && tree.Longitude == csvTree.Longitude
-		})
-		if idx == -1 {
-			createQueue = append(createQueue, csvTree)
-			continue
+	// the content hash is only known once the whole file was read
+	if converted.ContentHash != "" && !opts.Force {
+		result, err := i.checkDuplicate(ctx, converted.ContentHash)
+		if err != nil || result != nil {
+			return result, err
 		}
+	}
 
-		existingTree := allImportedTrees[idx]
-		if existingTree.PlantingYear == csvTree.PlantingYear {
-			csvTree.TreeID = existingTree.TreeID
-			updateQueue = append(updateQueue, csvTree)
-		} else {
-			deleteQueue = append(deleteQueue, existingTree.TreeID)
-			createQueue = append(createQueue, csvTree)
+	if plan.RequiresConfirmation && !opts.ConfirmDeletions {
+		return nil, &TooManyDeletionsError{
+			Deletions: len(plan.Deletes()),
+			Limit:     i.cfg.MaxDeletions,
 		}
 	}
 
-	err = i.importRepo.WithTx(ctx, func(ctx context.Context, tx *storage.ImportRepositoryTx) error {
-		if err := tx.CreateTrees(ctx, createQueue); err != nil {
-			return err
+	var contentHash *string
+	if converted.ContentHash != "" {
+		contentHash = &converted.ContentHash
+	}
+
+	userID := opts.UserID
+	if userID == "" {
+		userID = AnonymousUserID
+	}
+
+	var sourceEPSG, detectedEPSG *int
+	if converted.CRS != nil {
+		sourceEPSG = &converted.CRS.Used
+		if converted.CRS.Detected != 0 {
+			detectedEPSG = &converted.CRS.Detected
 		}
+	}
+
+	var transformation *string
+	var transformationAccuracy *float64
+	if converted.Transformation != nil {
+		transformation = &converted.Transformation.Pipeline
+		transformationAccuracy = converted.Transformation.Accuracy
+	}
+
+	importID, err := i.importRepo.CreateImport(ctx, entities.Import{
+		UserID:                 userID,
+		Status:                 entities.ImportStatusRunning,
+		ContentHash:            contentHash,
+		SourceEPSG:             sourceEPSG,
+		DetectedEPSG:           detectedEPSG,
+		Transformation:         transformation,
+		TransformationAccuracy: transformationAccuracy,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		if err := tx.UpdateTrees(ctx, updateQueue); err != nil {
-			return err
+	if opts.File != nil {
+		if err := i.saveImportFile(ctx, importID, opts.File, converted.Dialect); err != nil {
+			return nil, i.abortImport(ctx, importID, errors.Wrap(err, "failed to store import file"))
 		}
+	}
+
+	ops, err := i.journal(ctx, importID, plan.Changes)
+	if err != nil {
+		return nil, i.abortImport(ctx, importID, err)
+	}
+
+	result := newImportResult(importID, plan.Mode)
+	result.Unlinked = plan.Unlinked
+	result.Ambiguous = plan.Ambiguous
+	result.Unchanged = plan.Unchanged
+
+	if applyErr := i.apply(ctx, ops, result); applyErr != nil {
+		// the request context may already be cancelled, cleaning up must still happen
+		ctx = context.WithoutCancel(ctx)
 
-		if err := tx.DeleteTreesByID(ctx, deleteQueue); err != nil {
-			return err
+		result.Status = entities.ImportStatusPartiallyApplied
+		if i.cfg.CompensateOnFailure {
+			result.Status = i.compensate(ctx, importID)
 		}
 
-		return nil
-	})
+		return result, i.failImport(ctx, result, applyErr)
+	}
+
+	result.Status = entities.ImportStatusSucceeded
+	if err := i.importRepo.FinishImport(ctx, importID, result.Status, nil); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// saveImportFile stores the file recorded while the trees were read.
+func (i *ImportService) saveImportFile(ctx context.Context, importID entities.ImportID, spool *ImportFileSpool, dialect CSVDialect) error {
+	file, err := spool.ImportFile(dialect)
 	if err != nil {
 		return err
 	}
+	file.ImportID = importID
+	return i.importRepo.SaveImportFile(ctx, file)
+}
 
-	if err := i.clientRepo.CreateTrees(ctx, createQueue); err != nil {
-		return err
+// checkDuplicate looks for a succeeded import of the same content. Depending on
+// the configured policy it returns a *DuplicateImportError or a result that
+// reports that nothing changed. If there is no such import, both are nil.
+func (i *ImportService) checkDuplicate(ctx context.Context, contentHash string) (*ImportResult, error) {
+	previous, err := i.importRepo.FindImportByContentHash(ctx, contentHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to look up previous imports")
 	}
 
-	if err := i.clientRepo.UpdateTrees(ctx, updateQueue); err != nil {
-		return err
+	if i.cfg.Duplicates == DuplicatePolicySkip {
+		slog.Info("Skipping import of already imported content", "previous_import", previous.ID)
+
+		result := newImportResult(0, "")
+		result.Status = entities.ImportStatusSucceeded
+		result.DuplicateOf = &previous.ID
+		return result, nil
 	}
 
-	if err := i.clientRepo.DeleteTrees(ctx, deleteQueue); err != nil {
-		return err
+	return nil, &DuplicateImportError{
+		ImportID:  previous.ID,
+		CreatedAt: previous.CreatedAt,
+	}
+}
+
+// Resume replays the pending and failed operations of an interrupted import.
+// Imports that are still marked as running, because the plugin stopped while
+// applying them, and partially applied imports can be resumed. If a call fails
+// again, the import stays partially applied and can be resumed or compensated
+// later.
+//
+// An operation that was interrupted after the backend applied it but before it
+// was stored locally is still pending. Updates and deletes are sent to the
+// backend again, deleting a tree the backend no longer knows counts as applied.
+// For creates the backend is searched for the tree first, it is linked if it
+// was already created. If more than one tree in the backend matches, the
+// operation fails with storage.ErrAmbiguousTree and needs a manual review. A create whose tree could not be stored locally failed with
+// the backend id in the journal, the tree is stored without another backend
+// call.
+func (i *ImportService) Resume(ctx context.Context, importID entities.ImportID) (*ImportResult, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	imp, err := i.importRepo.GetImport(ctx, importID)
+	if err != nil {
+		return nil, err
 	}
 
-	usedTreeIDs := make([]entities.TreeID, len(createQueue)+len(updateQueue))
-	for i, tree := range createQueue {
-		usedTreeIDs[i] = tree.TreeID
+	if imp.Status != entities.ImportStatusRunning && imp.Status != entities.ImportStatusPartiallyApplied {
+		return nil, errors.Wrapf(ErrInvalidImportState, "import %d has status %s, only running or partially applied imports can be resumed", importID, imp.Status)
 	}
 
-	for i, tree := range updateQueue {
-		usedTreeIDs[i+len(createQueue)] = tree.TreeID
+	journal, err := i.importRepo.GetOperations(ctx, importID)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := i.importRepo.AddImport(ctx, entities.Import{
-		RawCSV: "raw-csv",    // TODO: Insert raw csv
-		UserID: "csv-import", // TODO: Insert user ID
-	}, usedTreeIDs); err != nil {
-		return err
+	ops := make([]*entities.ImportOperation, 0, len(journal))
+	for idx := range journal {
+		ops = append(ops, &journal[idx])
+	}
+
+	slog.Info("Resuming import", "import", importID, "operations", len(ops))
+
+	result := newImportResult(importID, "")
+	if applyErr := i.apply(ctx, ops, result); applyErr != nil {
+		result.Status = entities.ImportStatusPartiallyApplied
+		return result, i.failImport(context.WithoutCancel(ctx), result, applyErr)
+	}
+
+	result.Status = entities.ImportStatusSucceeded
+	if err := i.importRepo.FinishImport(ctx, importID, result.Status, nil); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// abortImport marks an import that failed before anything was applied as
+// rolled back.
+func (i *ImportService) abortImport(ctx context.Context, importID entities.ImportID, importErr error) error {
+	if err := i.importRepo.FinishImport(context.WithoutCancel(ctx), importID, entities.ImportStatusRolledBack, importErr); err != nil {
+		slog.Error("Failed to store import status", "import", importID, "error", err)
+	}
+	return importErr
+}
+
+// failImport stores the final status of a failed import and returns the
+// matching *ImportFailedError.
+func (i *ImportService) failImport(ctx context.Context, result *ImportResult, applyErr error) error {
+	if err := i.importRepo.FinishImport(ctx, result.ImportID, result.Status, applyErr); err != nil {
+		slog.Error("Failed to store import status", "import", result.ImportID, "error", err)
 	}
 
-	return nil
+	return &ImportFailedError{
+		ImportID: result.ImportID,
+		Status:   result.Status,
+		Err:      applyErr,
+	}
+}
+
+func newImportResult(importID entities.ImportID, mode SyncMode) *ImportResult {
+	return &ImportResult{
+		ImportID:  importID,
+		Mode:      mode,
+		Created:   make([]*entities.Tree, 0),
+		Updated:   make([]*entities.Tree, 0),
+		Deleted:   make([]entities.TreeID, 0),
+		Unlinked:  make([]*entities.Tree, 0),
+		Ambiguous: make([]AmbiguousMatch, 0),
+		Unchanged: make([]*entities.Tree, 0),
+		Errors:    make([]*TreeError, 0),
+	}
 }
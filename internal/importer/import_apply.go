@@ -0,0 +1,560 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/importer/storage"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/utils"
+	"github.com/pkg/errors"
+)
+
+// journal writes every planned change as a pending operation of the import
+// before the first backend call is made. The journal is what Resume replays
+// when an import was interrupted.
+func (i *ImportService) journal(ctx context.Context, importID entities.ImportID, changes []PlannedChange) ([]*entities.ImportOperation, error) {
+	ops := make([]*entities.ImportOperation, 0, len(changes))
+	for _, change := range changes {
+		op, err := newOperation(importID, change)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+
+	err := i.importRepo.WithTx(ctx, func(ctx context.Context, tx *storage.ImportRepositoryTx) error {
+		for _, op := range ops {
+			if err := tx.AddOperation(ctx, op); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write import journal")
+	}
+
+	return ops, nil
+}
+
+func newOperation(importID entities.ImportID, change PlannedChange) (*entities.ImportOperation, error) {
+	op := &entities.ImportOperation{
+		ImportID: importID,
+		Status:   entities.OperationStatusPending,
+	}
+
+	var err error
+	switch change.Action {
+	case ChangeActionCreate:
+		op.Operation = entities.OperationCreate
+		if op.Payload, err = encodeTree(change.Tree); err != nil {
+			return nil, err
+		}
+	case ChangeActionUpdate:
+		op.Operation = entities.OperationUpdate
+		op.TreeID = &change.Existing.TreeID
+		op.BackendID = change.Existing.BackendID
+		if op.Payload, err = encodeTree(change.Tree); err != nil {
+			return nil, err
+		}
+		if op.Previous, err = encodeTree(change.Existing); err != nil {
+			return nil, err
+		}
+		if op.Diff, err = encodeDiff(change.Diff); err != nil {
+			return nil, err
+		}
+	case ChangeActionDelete:
+		op.Operation = entities.OperationDelete
+		op.TreeID = &change.Existing.TreeID
+		op.BackendID = change.Existing.BackendID
+		if op.Previous, err = encodeTree(change.Existing); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("unknown change action %q", change.Action)
+	}
+
+	return op, nil
+}
+
+// apply runs the pending and failed operations of the journal with the
+// configured number of workers. A failed operation does not stop the others,
+// the errors of all failed operations are returned as an *ApplyError. Applied
+// operations are skipped, so the same journal can be applied again after an
+// interruption.
+func (i *ImportService) apply(ctx context.Context, ops []*entities.ImportOperation, result *ImportResult) error {
+	start := time.Now()
+
+	queue := utils.Filter(ops, func(op *entities.ImportOperation) bool {
+		return op.Status == entities.OperationStatusPending || op.Status == entities.OperationStatusFailed
+	})
+	trees := make([]*entities.Tree, len(queue))
+	errs := make([]error, len(queue))
+	started := make([]bool, len(queue))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(i.cfg.Concurrency, len(queue)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				started[idx] = true
+				trees[idx], errs[idx] = i.applyOperation(ctx, queue[idx])
+			}
+		}()
+	}
+
+feed:
+	for idx := range queue {
+		select {
+		case jobs <- idx:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// the results are collected in journal order, independent of which worker finished first
+	applyErr := &ApplyError{}
+	for idx, op := range queue {
+		if !started[idx] {
+			continue
+		}
+		result.Stats.Operations++
+
+		if errs[idx] != nil {
+			result.Stats.Failed++
+			treeErr := &TreeError{OperationID: op.ID, Operation: op.Operation, Tree: trees[idx], Err: errs[idx]}
+			applyErr.Errors = append(applyErr.Errors, treeErr)
+			result.Errors = append(result.Errors, treeErr)
+			continue
+		}
+
+		result.Stats.Applied++
+		switch op.Operation {
+		case entities.OperationCreate:
+			result.Created = append(result.Created, trees[idx])
+		case entities.OperationUpdate:
+			result.Updated = append(result.Updated, trees[idx])
+		case entities.OperationDelete:
+			result.Deleted = append(result.Deleted, trees[idx].TreeID)
+		}
+	}
+	result.Stats.Duration = time.Since(start)
+
+	slog.Info("Applied import operations", "import", result.ImportID, "operations", result.Stats.Operations, "failed", result.Stats.Failed, "elapsed", result.Stats.Duration, "per_second", result.Stats.Throughput())
+
+	if err := ctx.Err(); err != nil {
+		return errors.Wrapf(err, "import interrupted after %d of %d operations", result.Stats.Operations, len(queue))
+	}
+
+	if len(applyErr.Errors) > 0 {
+		return applyErr
+	}
+
+	return nil
+}
+
+// applyOperation counts the attempt in the journal, calls the backend and
+// stores the change locally once the backend confirmed it.
+func (i *ImportService) applyOperation(ctx context.Context, op *entities.ImportOperation) (*entities.Tree, error) {
+	op.Attempts++
+	op.Status = entities.OperationStatusPending
+	i.storeMu.Lock()
+	err := i.importRepo.UpdateOperation(ctx, op)
+	i.storeMu.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write import journal")
+	}
+
+	switch op.Operation {
+	case entities.OperationCreate:
+		tree, err := decodeTree(op.ID, op.Payload)
+		if err != nil {
+			return nil, err
+		}
+		return tree, i.applyCreate(ctx, op, tree)
+	case entities.OperationUpdate:
+		tree, err := decodeTree(op.ID, op.Payload)
+		if err != nil {
+			return nil, err
+		}
+		return tree, i.applyUpdate(ctx, op, tree)
+	case entities.OperationDelete:
+		tree, err := decodePrevious(op)
+		if err != nil {
+			return nil, err
+		}
+		return tree, i.applyDelete(ctx, op, tree)
+	default:
+		return nil, errors.Errorf("unknown operation %q", op.Operation)
+	}
+}
+
+func (i *ImportService) applyCreate(ctx context.Context, op *entities.ImportOperation, tree *entities.Tree) error {
+	var err error
+	if op.BackendID != nil {
+		// an earlier attempt created the tree but could not store it locally
+		tree.BackendID = op.BackendID
+		slog.Info("Linked tree created by an earlier attempt", "import", op.ImportID, "operation", op.ID, "tree_number", tree.Number, "backend_id", *tree.BackendID)
+	} else {
+		var stored storage.Position
+		found := false
+		if op.Attempts > 1 {
+			// an earlier attempt may have created the tree before it was interrupted
+			stored, found, err = i.clientRepo.FindCreatedTree(ctx, tree)
+			if err != nil {
+				return i.recordFailedOperation(ctx, op, err)
+			}
+			if found {
+				slog.Info("Linked tree created by an earlier attempt", "import", op.ImportID, "operation", op.ID, "tree_number", tree.Number, "backend_id", *tree.BackendID)
+			}
+		}
+
+		if !found {
+			stored, err = i.clientRepo.CreateTree(ctx, tree)
+			if err != nil {
+				return i.recordFailedOperation(ctx, op, err)
+			}
+		}
+		op.BackendID = tree.BackendID
+		i.checkRoundTrip(tree, stored)
+	}
+
+	i.storeMu.Lock()
+	err = i.importRepo.WithTx(ctx, func(ctx context.Context, tx *storage.ImportRepositoryTx) error {
+		if err := tx.CreateTrees(ctx, []*entities.Tree{tree}); err != nil {
+			return err
+		}
+
+		op.TreeID = &tree.TreeID
+		op.Status = entities.OperationStatusApplied
+		op.LastError = nil
+		if err := tx.UpdateOperation(ctx, op); err != nil {
+			return err
+		}
+
+		return tx.LinkTree(ctx, op.ImportID, tree.TreeID)
+	})
+	i.storeMu.Unlock()
+	if err != nil {
+		tree.TreeID = 0
+		op.TreeID = nil
+		return i.recordUnstoredOperation(ctx, op, err)
+	}
+
+	return nil
+}
+
+func (i *ImportService) applyUpdate(ctx context.Context, op *entities.ImportOperation, tree *entities.Tree) error {
+	stored, err := i.clientRepo.UpdateTree(ctx, tree)
+	if err != nil {
+		return i.recordFailedOperation(ctx, op, err)
+	}
+	i.checkRoundTrip(tree, stored)
+
+	i.storeMu.Lock()
+	err = i.importRepo.WithTx(ctx, func(ctx context.Context, tx *storage.ImportRepositoryTx) error {
+		if err := tx.UpdateTrees(ctx, []*entities.Tree{tree}); err != nil {
+			return err
+		}
+
+		op.Status = entities.OperationStatusApplied
+		op.LastError = nil
+		if err := tx.UpdateOperation(ctx, op); err != nil {
+			return err
+		}
+
+		return tx.LinkTree(ctx, op.ImportID, tree.TreeID)
+	})
+	i.storeMu.Unlock()
+	if err != nil {
+		return i.recordUnstoredOperation(ctx, op, err)
+	}
+
+	return nil
+}
+
+func (i *ImportService) applyDelete(ctx context.Context, op *entities.ImportOperation, existing *entities.Tree) error {
+	if err := i.clientRepo.DeleteTree(ctx, existing); err != nil {
+		return i.recordFailedOperation(ctx, op, err)
+	}
+
+	i.storeMu.Lock()
+	err := i.importRepo.WithTx(ctx, func(ctx context.Context, tx *storage.ImportRepositoryTx) error {
+		if err := tx.DeleteTreesByID(ctx, []entities.TreeID{existing.TreeID}); err != nil {
+			return err
+		}
+
+		op.Status = entities.OperationStatusApplied
+		op.LastError = nil
+		return tx.UpdateOperation(ctx, op)
+	})
+	i.storeMu.Unlock()
+	if err != nil {
+		return i.recordUnstoredOperation(ctx, op, err)
+	}
+
+	return nil
+}
+
+// recordFailedOperation marks an operation the backend rejected as failed, so
+// it is replayed by Resume.
+func (i *ImportService) recordFailedOperation(ctx context.Context, op *entities.ImportOperation, opErr error) error {
+	op.Status = entities.OperationStatusFailed
+	return i.recordOperationError(ctx, op, errors.Wrapf(opErr, "failed to %s tree in backend", op.Operation))
+}
+
+// recordUnstoredOperation marks an operation the backend applied but that
+// could not be written to the local database. Updates and deletes count as
+// applied, so they are not replayed and the compensation reverts them in the
+// backend. A created tree would be missing locally and be created again by the
+// next import, so the create is marked as failed with the backend id in the
+// journal. Resume then stores the tree without calling the backend again.
+func (i *ImportService) recordUnstoredOperation(ctx context.Context, op *entities.ImportOperation, opErr error) error {
+	op.Status = entities.OperationStatusApplied
+	if op.Operation == entities.OperationCreate {
+		op.Status = entities.OperationStatusFailed
+	}
+	return i.recordOperationError(ctx, op, errors.Wrapf(opErr, "failed to store %s of tree locally", op.Operation))
+}
+
+func (i *ImportService) recordOperationError(ctx context.Context, op *entities.ImportOperation, opErr error) error {
+	msg := opErr.Error()
+	op.LastError = &msg
+
+	i.storeMu.Lock()
+	defer i.storeMu.Unlock()
+
+	if err := i.importRepo.UpdateOperation(context.WithoutCancel(ctx), op); err != nil {
+		slog.Error("Failed to record import operation", "import", op.ImportID, "operation", op.ID, "error", err)
+	}
+
+	return opErr
+}
+
+var ErrInvalidImportState = errors.New("invalid import state")
+
+// Compensate reverts all applied operations of a partially applied import.
+func (i *ImportService) Compensate(ctx context.Context, importID entities.ImportID) (entities.ImportStatus, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	imp, err := i.importRepo.GetImport(ctx, importID)
+	if err != nil {
+		return "", err
+	}
+
+	if imp.Status != entities.ImportStatusPartiallyApplied {
+		return imp.Status, errors.Wrapf(ErrInvalidImportState, "import %d has status %s, only partially applied imports can be compensated", importID, imp.Status)
+	}
+
+	status := i.compensate(ctx, importID)
+	if err := i.importRepo.FinishImport(ctx, importID, status, nil); err != nil {
+		return status, err
+	}
+
+	return status, nil
+}
+
+// UndoConflictError is returned when an import cannot be undone because some
+// of its trees were changed again by later imports.
+type UndoConflictError struct {
+	ImportID  entities.ImportID
+	Conflicts []entities.TreeConflict
+}
+
+func (e *UndoConflictError) Error() string {
+	return fmt.Sprintf("import %d cannot be undone, %d of its trees were changed by later imports", e.ImportID, len(e.Conflicts))
+}
+
+// Undo reverts a finished import in the backend and locally: created trees are
+// deleted, updated trees get their previous values back and deleted trees are
+// created again. Only the latest import touching its trees can be undone,
+// otherwise an *UndoConflictError lists the later imports.
+func (i *ImportService) Undo(ctx context.Context, importID entities.ImportID) (entities.ImportStatus, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	imp, err := i.importRepo.GetImport(ctx, importID)
+	if err != nil {
+		return "", err
+	}
+
+	if imp.Status != entities.ImportStatusSucceeded && imp.Status != entities.ImportStatusPartiallyApplied {
+		return imp.Status, errors.Wrapf(ErrInvalidImportState, "import %d has status %s, only succeeded or partially applied imports can be undone", importID, imp.Status)
+	}
+
+	conflicts, err := i.importRepo.GetLaterImportConflicts(ctx, importID)
+	if err != nil {
+		return imp.Status, errors.Wrap(err, "failed to check later imports")
+	}
+	if len(conflicts) > 0 {
+		return imp.Status, &UndoConflictError{
+			ImportID:  importID,
+			Conflicts: conflicts,
+		}
+	}
+
+	// undoing must not stop halfway because the request was cancelled
+	ctx = context.WithoutCancel(ctx)
+
+	slog.Info("Undoing import", "import", importID)
+	status := i.compensate(ctx, importID)
+	if err := i.importRepo.FinishImport(ctx, importID, status, nil); err != nil {
+		return status, err
+	}
+
+	return status, nil
+}
+
+// compensate reverts the operations the backend applied in reverse order.
+// Created trees are deleted, updated trees get their previous values back and
+// deleted trees are created again. Operations that cannot be reverted keep
+// their error and leave the import partially applied.
+func (i *ImportService) compensate(ctx context.Context, importID entities.ImportID) entities.ImportStatus {
+	ops, err := i.importRepo.GetOperations(ctx, importID)
+	if err != nil {
+		slog.Error("Failed to load import operations for compensation", "import", importID, "error", err)
+		return entities.ImportStatusPartiallyApplied
+	}
+
+	status := entities.ImportStatusRolledBack
+	for idx := len(ops) - 1; idx >= 0; idx-- {
+		op := &ops[idx]
+		if !appliedInBackend(op) {
+			continue
+		}
+
+		if err := i.compensateOperation(ctx, op); err != nil {
+			slog.Error("Failed to compensate import operation", "import", importID, "operation", op.ID, "error", err)
+			status = entities.ImportStatusPartiallyApplied
+
+			msg := errors.Wrap(err, "compensation failed").Error()
+			op.LastError = &msg
+		} else {
+			op.Status = entities.OperationStatusCompensated
+		}
+
+		if err := i.importRepo.UpdateOperation(ctx, op); err != nil {
+			slog.Error("Failed to store compensated import operation", "import", importID, "operation", op.ID, "error", err)
+			status = entities.ImportStatusPartiallyApplied
+		}
+	}
+
+	return status
+}
+
+// appliedInBackend tells whether the backend applied the operation, including
+// creates whose tree could not be stored locally.
+func appliedInBackend(op *entities.ImportOperation) bool {
+	if op.Status == entities.OperationStatusApplied {
+		return true
+	}
+	return op.Operation == entities.OperationCreate && op.Status == entities.OperationStatusFailed && op.BackendID != nil
+}
+
+func (i *ImportService) compensateOperation(ctx context.Context, op *entities.ImportOperation) error {
+	switch op.Operation {
+	case entities.OperationCreate:
+		tree := &entities.Tree{BackendID: op.BackendID}
+		if op.TreeID != nil {
+			tree.TreeID = *op.TreeID
+		}
+
+		if err := i.clientRepo.DeleteTree(ctx, tree); err != nil {
+			return err
+		}
+
+		if op.TreeID != nil {
+			return i.importRepo.DeleteTreesByID(ctx, []entities.TreeID{*op.TreeID})
+		}
+		return nil
+	case entities.OperationUpdate:
+		previous, err := decodePrevious(op)
+		if err != nil {
+			return err
+		}
+
+		stored, err := i.clientRepo.UpdateTree(ctx, previous)
+		if err != nil {
+			return err
+		}
+		i.checkRoundTrip(previous, stored)
+
+		return i.importRepo.RestoreTrees(ctx, []*entities.Tree{previous})
+	case entities.OperationDelete:
+		previous, err := decodePrevious(op)
+		if err != nil {
+			return err
+		}
+
+		stored, err := i.clientRepo.CreateTree(ctx, previous)
+		if err != nil {
+			return err
+		}
+		op.BackendID = previous.BackendID
+		i.checkRoundTrip(previous, stored)
+
+		return i.importRepo.RestoreTrees(ctx, []*entities.Tree{previous})
+	default:
+		return errors.Errorf("unknown operation %q", op.Operation)
+	}
+}
+
+func encodeTree(tree *entities.Tree) (*string, error) {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode tree")
+	}
+
+	encoded := string(data)
+	return &encoded, nil
+}
+
+func decodeTree(opID entities.ImportOperationID, data *string) (*entities.Tree, error) {
+	if data == nil {
+		return nil, errors.Errorf("operation %d has no tree state", opID)
+	}
+
+	var tree entities.Tree
+	if err := json.Unmarshal([]byte(*data), &tree); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode tree state of operation %d", opID)
+	}
+
+	return &tree, nil
+}
+
+func encodeDiff(diff []entities.FieldChange) (*string, error) {
+	data, err := json.Marshal(diff)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode diff")
+	}
+
+	encoded := string(data)
+	return &encoded, nil
+}
+
+func decodeDiff(op *entities.ImportOperation) ([]entities.FieldChange, error) {
+	diff := make([]entities.FieldChange, 0)
+	if op.Diff == nil {
+		return diff, nil
+	}
+
+	if err := json.Unmarshal([]byte(*op.Diff), &diff); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode diff of operation %d", op.ID)
+	}
+
+	return diff, nil
+}
+
+func decodePrevious(op *entities.ImportOperation) (*entities.Tree, error) {
+	return decodeTree(op.ID, op.Previous)
+}
@@ -0,0 +1,66 @@
+package importer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+	"github.com/pkg/errors"
+)
+
+// ImportFileSpool records an uploaded CSV file while the converter reads it, so
+// it can be stored with the import without holding the whole file in memory.
+// It computes the checksum and keeps a gzip compressed copy of the content.
+type ImportFileSpool struct {
+	filename   string
+	checksum   hash.Hash
+	size       int64
+	compressed bytes.Buffer
+	writer     *gzip.Writer
+}
+
+func NewImportFileSpool(filename string) *ImportFileSpool {
+	s := &ImportFileSpool{
+		filename: filename,
+		checksum: sha256.New(),
+	}
+	s.writer = gzip.NewWriter(&s.compressed)
+	return s
+}
+
+// Reader returns a reader of r that records everything read from it.
+func (s *ImportFileSpool) Reader(r io.Reader) io.Reader {
+	return io.TeeReader(r, s)
+}
+
+func (s *ImportFileSpool) Write(p []byte) (int, error) {
+	s.checksum.Write(p)
+	s.size += int64(len(p))
+	return s.writer.Write(p)
+}
+
+// ImportFile describes the recorded file. It must only be called once the file
+// was read completely, the spool cannot be written afterwards.
+func (s *ImportFileSpool) ImportFile(dialect CSVDialect) (*entities.ImportFile, error) {
+	if err := s.writer.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to compress import file")
+	}
+
+	return &entities.ImportFile{
+		Filename:   s.filename,
+		SHA256:     hex.EncodeToString(s.checksum.Sum(nil)),
+		Encoding:   dialect.Encoding,
+		Size:       s.size,
+		Compressed: s.compressed.Bytes(),
+	}, nil
+}
+
+// GetImportFile returns the original file of an import.
+func (i *ImportService) GetImportFile(ctx context.Context, importID entities.ImportID) (*entities.ImportFile, error) {
+	return i.importRepo.GetImportFile(ctx, importID)
+}
@@ -0,0 +1,322 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/utils"
+	"github.com/pkg/errors"
+)
+
+type ChangeAction string
+
+const (
+	ChangeActionCreate ChangeAction = "create"
+	ChangeActionUpdate ChangeAction = "update"
+	ChangeActionDelete ChangeAction = "delete"
+)
+
+// PlannedChange describes what an import will do with a single tree. Tree holds
+// the values from the CSV and is nil for deletions, Existing holds the previously
+// imported values and is nil for creations.
+type PlannedChange struct {
+	Action   ChangeAction
+	Tree     *entities.Tree
+	Existing *entities.Tree
+	// Distance in metres between the CSV position and the previously imported
+	// position for matched trees.
+	Distance float64
+	Reason   string
+	// Diff lists the changed attributes of an update.
+	Diff []entities.FieldChange
+	// Replaces is the previously imported tree a creation replaces. Its
+	// deletion is planned as a separate change, both are applied or skipped
+	// together.
+	Replaces *entities.Tree
+}
+
+type ImportPlan struct {
+	Mode      SyncMode
+	Changes   []PlannedChange
+	Ambiguous []AmbiguousMatch
+	// Unchanged contains the CSV trees that match a previously imported tree
+	// without any difference. They are not sent to the backend.
+	Unchanged []*entities.Tree
+	// Unlinked contains previously imported trees that should be updated or
+	// deleted but have no known id in the Green Ecolution backend. Their
+	// changes are left out of the plan.
+	Unlinked []*entities.Tree
+	// RequiresConfirmation is set when the plan deletes more trees than the
+	// configured limit allows without an explicit confirmation.
+	RequiresConfirmation bool
+}
+
+// SyncMode decides what happens to previously imported trees that are missing
+// from a new CSV file.
+type SyncMode string
+
+const (
+	// SyncModeAdditive keeps trees that are missing from the CSV file.
+	SyncModeAdditive SyncMode = "additive"
+	// SyncModeFull deletes trees that are missing from the CSV file.
+	SyncModeFull SyncMode = "full"
+)
+
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch mode := SyncMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case SyncModeAdditive, SyncModeFull:
+		return mode, nil
+	default:
+		return "", errors.Errorf("unknown sync mode %q, expected %q or %q", s, SyncModeAdditive, SyncModeFull)
+	}
+}
+
+// TreeSource streams the trees of an import, usually a *CSVConverter. The
+// batches can only be read once, the result is complete afterwards. Its content
+// hash is used to detect repeated uploads, its CRS and transformation are
+// recorded with the import.
+type TreeSource interface {
+	Batches(ctx context.Context) iter.Seq2[[]*entities.Tree, error]
+	Result() *ConvertResult
+}
+
+// ImportOptions are chosen by the operator for a single import.
+type ImportOptions struct {
+	// Mode defaults to the configured sync mode if empty.
+	Mode SyncMode
+	// ConfirmDeletions allows more deletions than the configured limit.
+	ConfirmDeletions bool
+	// File records the uploaded CSV file while the trees are read, it is
+	// stored with the import.
+	File *ImportFileSpool
+	// Force runs the import even if the same content was imported before.
+	Force bool
+	// UserID is the user who started the import. It defaults to
+	// AnonymousUserID.
+	UserID entities.UserID
+}
+
+// AnonymousUserID is recorded for imports of unauthenticated users.
+const AnonymousUserID entities.UserID = "csv-import"
+
+// DuplicatePolicy decides what happens when a CSV file is uploaded whose
+// content was already imported successfully.
+type DuplicatePolicy string
+
+const (
+	// DuplicatePolicyReject refuses the import with a *DuplicateImportError.
+	DuplicatePolicyReject DuplicatePolicy = "reject"
+	// DuplicatePolicySkip reports that nothing changed without importing.
+	DuplicatePolicySkip DuplicatePolicy = "skip"
+)
+
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch policy := DuplicatePolicy(strings.ToLower(strings.TrimSpace(s))); policy {
+	case DuplicatePolicyReject, DuplicatePolicySkip:
+		return policy, nil
+	default:
+		return "", errors.Errorf("unknown duplicate policy %q, expected %q or %q", s, DuplicatePolicyReject, DuplicatePolicySkip)
+	}
+}
+
+// DuplicateImportError is returned when the content of a CSV file was already
+// imported and the import was not forced.
+type DuplicateImportError struct {
+	ImportID  entities.ImportID
+	CreatedAt time.Time
+}
+
+func (e *DuplicateImportError) Error() string {
+	return fmt.Sprintf("the same content was already imported by import %d at %s, use force to import it again", e.ImportID, e.CreatedAt.Format(time.DateTime))
+}
+
+// IncompleteFileError is returned for a full sync of a CSV file with invalid
+// rows. The trees of these rows are missing from the converted trees and would
+// be deleted.
+type IncompleteFileError struct {
+	InvalidRows int
+}
+
+func (e *IncompleteFileError) Error() string {
+	return fmt.Sprintf("a full sync would delete the trees of %d invalid rows, fix the rows or use the %s mode", e.InvalidRows, SyncModeAdditive)
+}
+
+// TooManyDeletionsError is returned when an import would delete more trees than
+// allowed without confirmation.
+type TooManyDeletionsError struct {
+	Deletions int
+	Limit     int
+}
+
+func (e *TooManyDeletionsError) Error() string {
+	return fmt.Sprintf("import would delete %d trees, more than the limit of %d, the deletions have to be confirmed", e.Deletions, e.Limit)
+}
+
+func (p *ImportPlan) Creates() []*entities.Tree {
+	return p.trees(ChangeActionCreate, func(c PlannedChange) *entities.Tree { return c.Tree })
+}
+
+func (p *ImportPlan) Updates() []*entities.Tree {
+	return p.trees(ChangeActionUpdate, func(c PlannedChange) *entities.Tree { return c.Tree })
+}
+
+func (p *ImportPlan) Deletes() []*entities.Tree {
+	return p.trees(ChangeActionDelete, func(c PlannedChange) *entities.Tree { return c.Existing })
+}
+
+func (p *ImportPlan) trees(action ChangeAction, fn func(PlannedChange) *entities.Tree) []*entities.Tree {
+	result := make([]*entities.Tree, 0)
+	for _, change := range p.Changes {
+		if change.Action == action {
+			result = append(result, fn(change))
+		}
+	}
+	return result
+}
+
+// Plan reads the trees of the source and computes the changes an import of
+// them would make without writing anything to the local database or the Green
+// Ecolution backend. The plan takes over the trees read from the source, their
+// coordinates are normalized and they get the ids of the matched trees.
+func (i *ImportService) Plan(ctx context.Context, source TreeSource, opts ImportOptions) (*ImportPlan, error) {
+	if opts.Mode == "" {
+		opts.Mode = i.cfg.SyncMode
+	}
+
+	// the matcher compares every tree with all others, so the batches are
+	// collected before planning
+	var trees []*entities.Tree
+	for batch, err := range source.Batches(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		trees = append(trees, batch...)
+	}
+
+	if invalidRows := source.Result().InvalidRows(); opts.Mode == SyncModeFull && invalidRows > 0 {
+		return nil, &IncompleteFileError{InvalidRows: invalidRows}
+	}
+
+	allImportedTrees, err := i.importRepo.GetAllTrees(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	normalizeCoordinates(trees, i.cfg.CoordinateDecimals)
+	plan := planImport(i.cfg.Match, opts.Mode, allImportedTrees, trees)
+	skipUnlinked(plan)
+
+	if deletions := len(plan.Deletes()); i.cfg.MaxDeletions >= 0 && deletions > i.cfg.MaxDeletions {
+		plan.RequiresConfirmation = true
+	}
+
+	return plan, nil
+}
+
+// skipUnlinked removes the changes of previously imported trees without a
+// backend id from the plan and reports the trees as unlinked.
+func skipUnlinked(plan *ImportPlan) {
+	plan.Unlinked = make([]*entities.Tree, 0)
+	plan.Changes = utils.Filter(plan.Changes, func(change PlannedChange) bool {
+		// a replacement is skipped together with the deletion of the replaced
+		// tree, otherwise the old tree would stay next to the new one
+		if change.Replaces != nil {
+			return change.Replaces.BackendID != nil
+		}
+
+		if change.Existing == nil || change.Existing.BackendID != nil {
+			return true
+		}
+		if change.Tree != nil {
+			plan.Unlinked = append(plan.Unlinked, change.Tree)
+		} else {
+			plan.Unlinked = append(plan.Unlinked, change.Existing)
+		}
+		return false
+	})
+}
+
+func planImport(cfg MatchConfig, mode SyncMode, allImportedTrees []entities.Tree, trees []*entities.Tree) *ImportPlan {
+	plan := &ImportPlan{
+		Mode:      mode,
+		Changes:   make([]PlannedChange, 0, len(trees)),
+		Unchanged: make([]*entities.Tree, 0),
+	}
+
+	matches, ambiguous := NewTreeMatcher(cfg, allImportedTrees).Match(trees)
+	plan.Ambiguous = ambiguous
+
+	matched := make(map[entities.TreeID]bool, len(matches))
+	for _, match := range matches {
+		matched[match.Existing.TreeID] = true
+	}
+
+	for _, csvTree := range trees {
+		match, ok := matches[csvTree]
+		if !ok {
+			plan.Changes = append(plan.Changes, PlannedChange{
+				Action: ChangeActionCreate,
+				Tree:   csvTree,
+				Reason: fmt.Sprintf("no previously imported tree within %.2f m", cfg.Tolerance),
+			})
+			continue
+		}
+
+		existingTree := match.Existing
+		if existingTree.PlantingYear == csvTree.PlantingYear {
+			csvTree.TreeID = existingTree.TreeID
+			csvTree.BackendID = existingTree.BackendID
+
+			diff := diffTrees(existingTree, csvTree)
+			if len(diff) == 0 {
+				plan.Unchanged = append(plan.Unchanged, csvTree)
+				continue
+			}
+
+			plan.Changes = append(plan.Changes, PlannedChange{
+				Action:   ChangeActionUpdate,
+				Tree:     csvTree,
+				Existing: existingTree,
+				Distance: match.Distance,
+				Reason:   fmt.Sprintf("matched previously imported tree %d at %.2f m with the same planting year", existingTree.TreeID, match.Distance),
+				Diff:     diff,
+			})
+		} else {
+			plan.Changes = append(plan.Changes,
+				PlannedChange{
+					Action:   ChangeActionDelete,
+					Existing: existingTree,
+					Distance: match.Distance,
+					Reason:   fmt.Sprintf("planting year changed from %d to %d, the tree was replaced", existingTree.PlantingYear, csvTree.PlantingYear),
+				},
+				PlannedChange{
+					Action:   ChangeActionCreate,
+					Tree:     csvTree,
+					Reason:   fmt.Sprintf("replaces tree %d planted in %d", existingTree.TreeID, existingTree.PlantingYear),
+					Replaces: existingTree,
+				},
+			)
+		}
+	}
+
+	if mode == SyncModeFull {
+		for idx := range allImportedTrees {
+			existingTree := &allImportedTrees[idx]
+			if matched[existingTree.TreeID] {
+				continue
+			}
+
+			plan.Changes = append(plan.Changes, PlannedChange{
+				Action:   ChangeActionDelete,
+				Existing: existingTree,
+				Reason:   "previously imported tree is missing from the CSV file",
+			})
+		}
+	}
+
+	return plan
+}
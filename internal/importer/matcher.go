@@ -0,0 +1,211 @@
+package importer
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+)
+
+const (
+	earthRadius      = 6371008.8 // mean earth radius in metres
+	metresPerDegree  = earthRadius * math.Pi / 180
+	minMatchCellSize = 0.01 // metres, keeps the grid usable with a zero tolerance
+)
+
+// MatchConfig controls how trees from a CSV file are matched to previously
+// imported trees.
+type MatchConfig struct {
+	// Tolerance is the maximum distance in metres between two positions of the
+	// same tree.
+	Tolerance float64
+	// MatchNumber additionally requires the tree numbers to be equal.
+	MatchNumber bool
+	// MatchArea additionally requires the areas to be equal.
+	MatchArea bool
+}
+
+var DefaultMatchConfig = MatchConfig{
+	Tolerance: 1.0,
+}
+
+// TreeMatch links a tree from the CSV file to a previously imported tree.
+type TreeMatch struct {
+	Existing *entities.Tree
+	Distance float64
+}
+
+// AmbiguousMatch is reported when more than one previously imported tree was a
+// candidate for a tree from the CSV file. Chosen is the candidate the tree was
+// matched to, or nil if all candidates were taken by closer trees.
+type AmbiguousMatch struct {
+	Tree       *entities.Tree
+	Candidates []TreeMatch
+	Chosen     *entities.Tree
+}
+
+type matchCell struct {
+	x, y int
+}
+
+type TreeMatcher struct {
+	cfg       MatchConfig
+	existing  []entities.Tree
+	maxAbsLat float64
+	cellLat   float64
+	cellLng   float64
+	cells     map[matchCell][]int
+}
+
+// NewTreeMatcher indexes the previously imported trees on a grid whose cells
+// are at least as large as the tolerance, so candidates only need to be
+// searched in neighbouring cells.
+func NewTreeMatcher(cfg MatchConfig, existing []entities.Tree) *TreeMatcher {
+	m := &TreeMatcher{
+		cfg:      cfg,
+		existing: existing,
+	}
+	maxAbsLat := 0.0
+	for _, tree := range existing {
+		maxAbsLat = max(maxAbsLat, math.Abs(tree.Latitude))
+	}
+	m.index(maxAbsLat)
+	return m
+}
+
+// index builds the grid for positions up to maxAbsLat. Cells get narrower
+// towards the poles, so the most poleward position decides their width.
+func (m *TreeMatcher) index(maxAbsLat float64) {
+	cellSize := max(m.cfg.Tolerance, minMatchCellSize)
+	lngScale := max(math.Cos(math.Min(maxAbsLat, 89)*math.Pi/180), 0.01)
+
+	m.maxAbsLat = maxAbsLat
+	m.cellLat = cellSize / metresPerDegree
+	m.cellLng = cellSize / (metresPerDegree * lngScale)
+	m.cells = make(map[matchCell][]int)
+
+	for i, tree := range m.existing {
+		cell := m.cellOf(tree.Latitude, tree.Longitude)
+		m.cells[cell] = append(m.cells[cell], i)
+	}
+}
+
+// Match assigns each tree at most one previously imported tree and each
+// previously imported tree to at most one tree. Candidate pairs are resolved
+// by ascending distance, ties are broken by the order of the trees and the
+// lower local id, so the result does not depend on map iteration order.
+func (m *TreeMatcher) Match(trees []*entities.Tree) (map[*entities.Tree]TreeMatch, []AmbiguousMatch) {
+	type candidate struct {
+		treeIdx     int
+		existingIdx int
+		distance    float64
+	}
+
+	// the grid has to be wide enough for the trees that are searched as well
+	maxAbsLat := m.maxAbsLat
+	for _, tree := range trees {
+		maxAbsLat = max(maxAbsLat, math.Abs(tree.Latitude))
+	}
+	if maxAbsLat > m.maxAbsLat {
+		m.index(maxAbsLat)
+	}
+
+	candidates := make([]candidate, 0, len(trees))
+	candidatesPerTree := make([][]TreeMatch, len(trees))
+	for treeIdx, tree := range trees {
+		for _, existingIdx := range m.neighbours(tree.Latitude, tree.Longitude) {
+			existing := &m.existing[existingIdx]
+			if !m.attributesMatch(tree, existing) {
+				continue
+			}
+
+			distance := Distance(tree.Latitude, tree.Longitude, existing.Latitude, existing.Longitude)
+			if distance > m.cfg.Tolerance {
+				continue
+			}
+
+			candidates = append(candidates, candidate{treeIdx, existingIdx, distance})
+			candidatesPerTree[treeIdx] = append(candidatesPerTree[treeIdx], TreeMatch{Existing: existing, Distance: distance})
+		}
+	}
+
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return cmp.Or(
+			cmp.Compare(a.distance, b.distance),
+			cmp.Compare(a.treeIdx, b.treeIdx),
+			cmp.Compare(m.existing[a.existingIdx].TreeID, m.existing[b.existingIdx].TreeID),
+		)
+	})
+
+	matches := make(map[*entities.Tree]TreeMatch, len(trees))
+	usedExisting := make(map[int]bool, len(candidates))
+	for _, c := range candidates {
+		tree := trees[c.treeIdx]
+		if _, ok := matches[tree]; ok || usedExisting[c.existingIdx] {
+			continue
+		}
+		matches[tree] = TreeMatch{Existing: &m.existing[c.existingIdx], Distance: c.distance}
+		usedExisting[c.existingIdx] = true
+	}
+
+	ambiguous := make([]AmbiguousMatch, 0)
+	for treeIdx, treeCandidates := range candidatesPerTree {
+		if len(treeCandidates) < 2 {
+			continue
+		}
+
+		slices.SortFunc(treeCandidates, func(a, b TreeMatch) int {
+			return cmp.Or(cmp.Compare(a.Distance, b.Distance), cmp.Compare(a.Existing.TreeID, b.Existing.TreeID))
+		})
+
+		tree := trees[treeIdx]
+		ambiguousMatch := AmbiguousMatch{Tree: tree, Candidates: treeCandidates}
+		if match, ok := matches[tree]; ok {
+			ambiguousMatch.Chosen = match.Existing
+		}
+		ambiguous = append(ambiguous, ambiguousMatch)
+	}
+
+	return matches, ambiguous
+}
+
+func (m *TreeMatcher) attributesMatch(tree, existing *entities.Tree) bool {
+	if m.cfg.MatchNumber && !strings.EqualFold(strings.TrimSpace(tree.Number), strings.TrimSpace(existing.Number)) {
+		return false
+	}
+	if m.cfg.MatchArea && !strings.EqualFold(strings.TrimSpace(tree.Area), strings.TrimSpace(existing.Area)) {
+		return false
+	}
+	return true
+}
+
+func (m *TreeMatcher) cellOf(lat, lng float64) matchCell {
+	return matchCell{
+		x: int(math.Floor(lng / m.cellLng)),
+		y: int(math.Floor(lat / m.cellLat)),
+	}
+}
+
+func (m *TreeMatcher) neighbours(lat, lng float64) []int {
+	center := m.cellOf(lat, lng)
+	result := make([]int, 0)
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			result = append(result, m.cells[matchCell{center.x + dx, center.y + dy}]...)
+		}
+	}
+	return result
+}
+
+// Distance returns the great-circle distance in metres between two WGS84 positions.
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLng := (lng2 - lng1) * toRad
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
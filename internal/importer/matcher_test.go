@@ -0,0 +1,195 @@
+package importer
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+)
+
+// the test trees are placed around this position
+const matcherOriginLat, matcherOriginLng = 54.78, 9.43
+
+// treeAt returns a tree the given number of metres east and north of the
+// origin.
+func treeAt(id entities.TreeID, number string, east, north float64) entities.Tree {
+	return entities.Tree{
+		TreeID:    id,
+		Number:    number,
+		Latitude:  matcherOriginLat + north/metresPerDegree,
+		Longitude: matcherOriginLng + east/(metresPerDegree*math.Cos(matcherOriginLat*math.Pi/180)),
+	}
+}
+
+func TestTreeMatcherMatch(t *testing.T) {
+	type ambiguous struct {
+		tree       int
+		candidates []entities.TreeID
+		chosen     entities.TreeID
+	}
+
+	tests := []struct {
+		name     string
+		cfg      MatchConfig
+		existing []entities.Tree
+		trees    []entities.Tree
+		// want holds the id of the matched tree per CSV tree, 0 if unmatched
+		want          []entities.TreeID
+		wantAmbiguous []ambiguous
+	}{
+		{
+			name:     "closest tree wins",
+			cfg:      DefaultMatchConfig,
+			existing: []entities.Tree{treeAt(1, "1", 0, 0)},
+			trees:    []entities.Tree{treeAt(0, "1", 0.6, 0), treeAt(0, "1", 0.3, 0)},
+			want:     []entities.TreeID{0, 1},
+		},
+		{
+			name:     "closest tree wins independent of the order",
+			cfg:      DefaultMatchConfig,
+			existing: []entities.Tree{treeAt(1, "1", 0, 0)},
+			trees:    []entities.Tree{treeAt(0, "1", 0.3, 0), treeAt(0, "1", 0.6, 0)},
+			want:     []entities.TreeID{1, 0},
+		},
+		{
+			name:     "outside the tolerance",
+			cfg:      DefaultMatchConfig,
+			existing: []entities.Tree{treeAt(1, "1", 0, 0)},
+			trees:    []entities.Tree{treeAt(0, "1", 0, 1.2)},
+			want:     []entities.TreeID{0},
+		},
+		{
+			name:     "tie broken by the order of the trees",
+			cfg:      DefaultMatchConfig,
+			existing: []entities.Tree{treeAt(1, "1", 0, 0)},
+			trees:    []entities.Tree{treeAt(0, "1", 0.5, 0), treeAt(0, "1", 0.5, 0)},
+			want:     []entities.TreeID{1, 0},
+		},
+		{
+			name:          "tie broken by the lower id",
+			cfg:           DefaultMatchConfig,
+			existing:      []entities.Tree{treeAt(2, "1", 0.5, 0), treeAt(1, "1", 0.5, 0)},
+			trees:         []entities.Tree{treeAt(0, "1", 0, 0)},
+			want:          []entities.TreeID{1},
+			wantAmbiguous: []ambiguous{{tree: 0, candidates: []entities.TreeID{1, 2}, chosen: 1}},
+		},
+		{
+			name:     "ambiguous trees matched to different candidates",
+			cfg:      DefaultMatchConfig,
+			existing: []entities.Tree{treeAt(1, "1", 0, 0), treeAt(2, "2", 0.8, 0)},
+			trees:    []entities.Tree{treeAt(0, "1", 0.1, 0), treeAt(0, "1", -0.05, 0)},
+			want:     []entities.TreeID{2, 1},
+			wantAmbiguous: []ambiguous{
+				{tree: 0, candidates: []entities.TreeID{1, 2}, chosen: 2},
+				{tree: 1, candidates: []entities.TreeID{1, 2}, chosen: 1},
+			},
+		},
+		{
+			name:     "all candidates taken by closer trees",
+			cfg:      DefaultMatchConfig,
+			existing: []entities.Tree{treeAt(1, "1", 0, 0), treeAt(2, "2", 0.8, 0)},
+			trees:    []entities.Tree{treeAt(0, "1", 0.3, 0), treeAt(0, "1", 0.1, 0), treeAt(0, "2", 0.7, 0)},
+			want:     []entities.TreeID{0, 1, 2},
+			wantAmbiguous: []ambiguous{
+				{tree: 0, candidates: []entities.TreeID{1, 2}},
+				{tree: 1, candidates: []entities.TreeID{1, 2}, chosen: 1},
+				{tree: 2, candidates: []entities.TreeID{2, 1}, chosen: 2},
+			},
+		},
+		{
+			name:     "number has to match",
+			cfg:      MatchConfig{Tolerance: 1, MatchNumber: true},
+			existing: []entities.Tree{treeAt(1, "1", 0, 0), treeAt(2, "2", 0.5, 0)},
+			trees:    []entities.Tree{treeAt(0, " 2 ", 0.1, 0)},
+			want:     []entities.TreeID{2},
+		},
+		{
+			name:     "tree north of all previously imported trees",
+			cfg:      DefaultMatchConfig,
+			existing: []entities.Tree{treeAt(1, "1", 0, -0.4)},
+			trees:    []entities.Tree{treeAt(0, "1", 0.9, 0)},
+			want:     []entities.TreeID{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trees := make([]*entities.Tree, len(tt.trees))
+			for i := range tt.trees {
+				trees[i] = &tt.trees[i]
+			}
+
+			matches, ambiguousMatches := NewTreeMatcher(tt.cfg, tt.existing).Match(trees)
+
+			for i, tree := range trees {
+				var got entities.TreeID
+				if match, ok := matches[tree]; ok {
+					got = match.Existing.TreeID
+				}
+				if got != tt.want[i] {
+					t.Errorf("tree %d: matched %d, expected %d", i, got, tt.want[i])
+				}
+			}
+
+			if len(ambiguousMatches) != len(tt.wantAmbiguous) {
+				t.Fatalf("got %d ambiguous matches, expected %d", len(ambiguousMatches), len(tt.wantAmbiguous))
+			}
+			for i, want := range tt.wantAmbiguous {
+				got := ambiguousMatches[i]
+				if got.Tree != trees[want.tree] {
+					t.Errorf("ambiguous match %d: got tree %v, expected tree %d", i, got.Tree, want.tree)
+				}
+
+				candidates := make([]entities.TreeID, 0, len(got.Candidates))
+				for _, candidate := range got.Candidates {
+					candidates = append(candidates, candidate.Existing.TreeID)
+				}
+				if !slices.Equal(candidates, want.candidates) {
+					t.Errorf("ambiguous match %d: got candidates %v, expected %v", i, candidates, want.candidates)
+				}
+
+				var chosen entities.TreeID
+				if got.Chosen != nil {
+					chosen = got.Chosen.TreeID
+				}
+				if chosen != want.chosen {
+					t.Errorf("ambiguous match %d: chose %d, expected %d", i, chosen, want.chosen)
+				}
+			}
+		})
+	}
+}
+
+func TestTreeMatcherMatchIsDeterministic(t *testing.T) {
+	existing := make([]entities.Tree, 0, 50)
+	trees := make([]entities.Tree, 0, 50)
+	for i := range 50 {
+		// rows of trees half a metre apart, so every tree has several candidates
+		existing = append(existing, treeAt(entities.TreeID(i+1), "", float64(i%10)*0.5, float64(i/10)*0.5))
+		trees = append(trees, treeAt(0, "", float64(i%10)*0.5+0.2, float64(i/10)*0.5+0.1))
+	}
+
+	match := func() map[int]entities.TreeID {
+		pointers := make([]*entities.Tree, len(trees))
+		for i := range trees {
+			pointers[i] = &trees[i]
+		}
+		matches, _ := NewTreeMatcher(DefaultMatchConfig, slices.Clone(existing)).Match(pointers)
+
+		result := make(map[int]entities.TreeID, len(matches))
+		for i, tree := range pointers {
+			if match, ok := matches[tree]; ok {
+				result[i] = match.Existing.TreeID
+			}
+		}
+		return result
+	}
+
+	first := match()
+	for range 20 {
+		if got := match(); !maps.Equal(got, first) {
+			t.Fatalf("got %v, expected %v", got, first)
+		}
+	}
+}
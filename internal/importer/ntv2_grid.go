@@ -0,0 +1,224 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NTv2 files consist of records of 16 bytes, an 8 byte name and an 8 byte
+// value.
+const (
+	ntv2RecordSize     = 16
+	ntv2OverviewFields = 11
+	ntv2SubgridFields  = 11
+	ntv2NodeSize       = 16
+)
+
+// ntv2Grid holds the datum shifts of an NTv2 grid file such as BeTA2007.
+type ntv2Grid struct {
+	subgrids []ntv2Subgrid
+}
+
+// ntv2Subgrid covers an area with a regular grid of shifts. Like the file
+// format it uses arc seconds and positive west longitudes.
+type ntv2Subgrid struct {
+	south, north float64
+	east, west   float64
+	latInc       float64
+	lonInc       float64
+	columns      int
+	rows         int
+	// shifts holds the latitude and longitude shift of each node, rows from
+	// south to north and every row from east to west
+	shifts []float32
+}
+
+// loadNTv2Grid reads a grid file from disk. Grids are read once when the
+// transformer is created, they are never downloaded.
+func loadNTv2Grid(path string) (*ntv2Grid, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read grid file")
+	}
+
+	if len(data) < ntv2OverviewFields*ntv2RecordSize || !bytes.HasPrefix(data, ntv2Magic) {
+		return nil, errors.Errorf("%s is not an NTv2 grid file", path)
+	}
+
+	// the number of overview records tells the byte order of the file
+	var order binary.ByteOrder = binary.LittleEndian
+	if binary.LittleEndian.Uint32(data[8:]) != ntv2OverviewFields {
+		order = binary.BigEndian
+	}
+
+	r := &ntv2Reader{data: data, order: order}
+	overview := r.records(ntv2OverviewFields)
+	if !strings.HasPrefix(overview.text("GS_TYPE"), "SECONDS") {
+		return nil, errors.Errorf("%s uses unsupported units %q", path, overview.text("GS_TYPE"))
+	}
+
+	grid := &ntv2Grid{}
+	for range overview.int("NUM_FILE") {
+		header := r.records(ntv2SubgridFields)
+		if r.err != nil {
+			break
+		}
+
+		subgrid := ntv2Subgrid{
+			south:  header.float("S_LAT"),
+			north:  header.float("N_LAT"),
+			east:   header.float("E_LONG"),
+			west:   header.float("W_LONG"),
+			latInc: header.float("LAT_INC"),
+			lonInc: header.float("LONG_INC"),
+		}
+		if subgrid.latInc <= 0 || subgrid.lonInc <= 0 {
+			return nil, errors.Errorf("%s contains a subgrid without increments", path)
+		}
+		subgrid.columns = int(math.Round((subgrid.west-subgrid.east)/subgrid.lonInc)) + 1
+		subgrid.rows = int(math.Round((subgrid.north-subgrid.south)/subgrid.latInc)) + 1
+		if subgrid.columns < 2 || subgrid.rows < 2 {
+			return nil, errors.Errorf("%s contains a subgrid with less than two rows or columns", path)
+		}
+
+		count := header.int("GS_COUNT")
+		if count != subgrid.columns*subgrid.rows {
+			return nil, errors.Errorf("%s contains a subgrid with %d nodes, expected %d", path, count, subgrid.columns*subgrid.rows)
+		}
+
+		subgrid.shifts = make([]float32, 0, 2*count)
+		for range count {
+			node := r.next(ntv2NodeSize)
+			if r.err != nil {
+				break
+			}
+			subgrid.shifts = append(subgrid.shifts,
+				math.Float32frombits(order.Uint32(node[0:])),
+				math.Float32frombits(order.Uint32(node[4:])))
+		}
+
+		grid.subgrids = append(grid.subgrids, subgrid)
+	}
+
+	if r.err != nil {
+		return nil, errors.Wrapf(r.err, "%s is truncated", path)
+	}
+	if len(grid.subgrids) == 0 {
+		return nil, errors.Errorf("%s contains no subgrids", path)
+	}
+
+	return grid, nil
+}
+
+// shift applies the datum shift to geographic coordinates in degrees. It
+// returns false if the position is not covered by the grid.
+func (g *ntv2Grid) shift(lon, lat float64) (float64, float64, bool) {
+	latSec := lat * 3600
+	lonSec := -lon * 3600 // positive west
+
+	// the finest subgrid covering the position is the most accurate one
+	var best *ntv2Subgrid
+	for i := range g.subgrids {
+		s := &g.subgrids[i]
+		if latSec < s.south || latSec > s.north || lonSec < s.east || lonSec > s.west {
+			continue
+		}
+		if best == nil || s.latInc < best.latInc {
+			best = s
+		}
+	}
+	if best == nil {
+		return 0, 0, false
+	}
+
+	dLat, dLon := best.interpolate(latSec, lonSec)
+	return lon - dLon/3600, lat + dLat/3600, true
+}
+
+// interpolate returns the bilinear interpolated shifts in arc seconds.
+func (s *ntv2Subgrid) interpolate(latSec, lonSec float64) (dLat, dLon float64) {
+	x := (lonSec - s.east) / s.lonInc
+	y := (latSec - s.south) / s.latInc
+
+	col := min(int(x), s.columns-2)
+	row := min(int(y), s.rows-2)
+	fx := x - float64(col)
+	fy := y - float64(row)
+
+	node := func(c, r int) (float64, float64) {
+		i := 2 * (r*s.columns + c)
+		return float64(s.shifts[i]), float64(s.shifts[i+1])
+	}
+
+	lat00, lon00 := node(col, row)
+	lat10, lon10 := node(col+1, row)
+	lat01, lon01 := node(col, row+1)
+	lat11, lon11 := node(col+1, row+1)
+
+	dLat = (1-fy)*((1-fx)*lat00+fx*lat10) + fy*((1-fx)*lat01+fx*lat11)
+	dLon = (1-fy)*((1-fx)*lon00+fx*lon10) + fy*((1-fx)*lon01+fx*lon11)
+	return dLat, dLon
+}
+
+// ntv2Reader reads consecutive records and remembers the first error.
+type ntv2Reader struct {
+	data   []byte
+	offset int
+	order  binary.ByteOrder
+	err    error
+}
+
+func (r *ntv2Reader) next(size int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.offset+size > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.data[r.offset : r.offset+size]
+	r.offset += size
+	return b
+}
+
+func (r *ntv2Reader) records(count int) ntv2Records {
+	records := ntv2Records{order: r.order, values: make(map[string][]byte, count)}
+	for range count {
+		record := r.next(ntv2RecordSize)
+		if record == nil {
+			break
+		}
+		records.values[strings.TrimSpace(string(record[:8]))] = record[8:]
+	}
+	return records
+}
+
+// ntv2Records are the header records of the overview or a subgrid by name.
+type ntv2Records struct {
+	order  binary.ByteOrder
+	values map[string][]byte
+}
+
+func (r ntv2Records) int(name string) int {
+	if v, ok := r.values[name]; ok {
+		return int(int32(r.order.Uint32(v)))
+	}
+	return 0
+}
+
+func (r ntv2Records) float(name string) float64 {
+	if v, ok := r.values[name]; ok {
+		return math.Float64frombits(r.order.Uint64(v))
+	}
+	return 0
+}
+
+func (r ntv2Records) text(name string) string {
+	return strings.TrimSpace(string(r.values[name]))
+}
@@ -0,0 +1,155 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSubgrid describes a subgrid of a test grid file in degrees, with east
+// longitudes. shift returns the latitude and longitude shift in arc seconds of
+// a node, longitude shifts are positive west as in the file format.
+type testSubgrid struct {
+	name, parent string
+	south, north float64
+	west, east   float64
+	inc          float64
+	shift        func(row, col int) (dLat, dLon float32)
+}
+
+// buildNTv2Grid encodes the subgrids as an NTv2 grid file.
+func buildNTv2Grid(order binary.ByteOrder, subgrids []testSubgrid) []byte {
+	var buf bytes.Buffer
+	text := func(name, value string) {
+		buf.WriteString((name + "        ")[:8])
+		buf.WriteString((value + "        ")[:8])
+	}
+	integer := func(name string, value int) {
+		buf.WriteString((name + "        ")[:8])
+		_ = binary.Write(&buf, order, int32(value))
+		buf.Write(make([]byte, 4))
+	}
+	float := func(name string, value float64) {
+		buf.WriteString((name + "        ")[:8])
+		_ = binary.Write(&buf, order, value)
+	}
+
+	integer("NUM_OREC", ntv2OverviewFields)
+	integer("NUM_SREC", ntv2SubgridFields)
+	integer("NUM_FILE", len(subgrids))
+	text("GS_TYPE", "SECONDS")
+	text("VERSION", "NTv2.0")
+	text("SYSTEM_F", "DHDN")
+	text("SYSTEM_T", "ETRS89")
+	float("MAJOR_F", ellipsoidBessel1841.a)
+	float("MINOR_F", ellipsoidBessel1841.a*(1-ellipsoidBessel1841.f))
+	float("MAJOR_T", ellipsoidGRS80.a)
+	float("MINOR_T", ellipsoidGRS80.a*(1-ellipsoidGRS80.f))
+
+	for _, s := range subgrids {
+		rows := int(math.Round((s.north-s.south)/s.inc)) + 1
+		columns := int(math.Round((s.east-s.west)/s.inc)) + 1
+
+		text("SUB_NAME", s.name)
+		text("PARENT", s.parent)
+		text("CREATED", "20241226")
+		text("UPDATED", "20241226")
+		float("S_LAT", s.south*3600)
+		float("N_LAT", s.north*3600)
+		float("E_LONG", -s.east*3600)
+		float("W_LONG", -s.west*3600)
+		float("LAT_INC", s.inc*3600)
+		float("LONG_INC", s.inc*3600)
+		integer("GS_COUNT", rows*columns)
+
+		// rows from south to north, every row from east to west
+		for row := range rows {
+			for col := range columns {
+				dLat, dLon := s.shift(row, col)
+				_ = binary.Write(&buf, order, []float32{dLat, dLon, 0, 0})
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func writeNTv2Grid(t *testing.T, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "grid.gsb")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func constantShift(dLat, dLon float32) func(row, col int) (float32, float32) {
+	return func(row, col int) (float32, float32) {
+		return dLat, dLon
+	}
+}
+
+func TestLoadNTv2Grid(t *testing.T) {
+	germany := testSubgrid{name: "DE", parent: "NONE", south: 47, north: 56, west: 5, east: 16, inc: 0.5, shift: constantShift(1, 2)}
+	// the finer subgrid around Flensburg overrides the coarse one
+	flensburg := testSubgrid{name: "FL", parent: "DE", south: 54.5, north: 55, west: 9, east: 10, inc: 0.25, shift: constantShift(3, 4)}
+	// shifts that grow by one arc second per node to the north and to the west
+	gradient := testSubgrid{name: "GRAD", parent: "NONE", south: 54, north: 55, west: 9, east: 10, inc: 0.5, shift: func(row, col int) (float32, float32) {
+		return float32(row), float32(col)
+	}}
+
+	valid := buildNTv2Grid(binary.LittleEndian, []testSubgrid{germany})
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantErr  bool
+		lon, lat float64
+		// shifts in arc seconds, dLon positive west
+		dLat, dLon float64
+		outside    bool
+	}{
+		{name: "little endian", data: valid, lon: 9.43, lat: 54.78, dLat: 1, dLon: 2},
+		{name: "big endian", data: buildNTv2Grid(binary.BigEndian, []testSubgrid{germany}), lon: 9.43, lat: 54.78, dLat: 1, dLon: 2},
+		{name: "outside", data: valid, lon: 20, lat: 54.78, outside: true},
+		{name: "nested subgrid", data: buildNTv2Grid(binary.LittleEndian, []testSubgrid{germany, flensburg}), lon: 9.43, lat: 54.78, dLat: 3, dLon: 4},
+		{name: "outside nested subgrid", data: buildNTv2Grid(binary.LittleEndian, []testSubgrid{germany, flensburg}), lon: 11.57, lat: 48.14, dLat: 1, dLon: 2},
+		{name: "bilinear", data: buildNTv2Grid(binary.LittleEndian, []testSubgrid{gradient}), lon: 9.75, lat: 54.25, dLat: 0.5, dLon: 0.5},
+		{name: "truncated nodes", data: valid[:len(valid)-8], wantErr: true},
+		{name: "truncated header", data: valid[:(ntv2OverviewFields+5)*ntv2RecordSize], wantErr: true},
+		{name: "too short", data: valid[:ntv2RecordSize], wantErr: true},
+		{name: "no grid", data: bytes.Repeat([]byte{'x'}, 1024), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grid, err := loadNTv2Grid(writeNTv2Grid(t, tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lon, lat, ok := grid.shift(tt.lon, tt.lat)
+			if ok == tt.outside {
+				t.Fatalf("got covered %t, expected %t", ok, !tt.outside)
+			}
+			if tt.outside {
+				return
+			}
+
+			wantLon := tt.lon - tt.dLon/3600
+			wantLat := tt.lat + tt.dLat/3600
+			if math.Abs(lon-wantLon) > 1e-9 || math.Abs(lat-wantLat) > 1e-9 {
+				t.Errorf("got %.9f, %.9f, expected %.9f, %.9f", lon, lat, wantLon, wantLat)
+			}
+		})
+	}
+}
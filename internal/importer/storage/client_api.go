@@ -1,24 +1,42 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
 
 	"github.com/green-ecolution/green-ecolution-backend/client"
 	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+	"github.com/pkg/errors"
 )
 
+// GreenEcolutionRepo is safe for concurrent use. Failed calls are retried as
+// configured by the RetryConfig.
 type GreenEcolutionRepo struct {
+	cfg    *client.Configuration
 	client *client.APIClient
+	retry  RetryConfig
 }
 
-func NewGreenEcolutionRepo(cfg *client.Configuration) *GreenEcolutionRepo {
+func NewGreenEcolutionRepo(cfg *client.Configuration, retry RetryConfig) *GreenEcolutionRepo {
 	return &GreenEcolutionRepo{
+		cfg:    cfg,
 		client: client.NewAPIClient(cfg),
+		retry:  retry,
 	}
 }
 
 func (r *GreenEcolutionRepo) GetInfo(ctx context.Context) (*client.AppInfo, error) {
-	info, _, err := r.client.InfoAPI.GetAppInfo(ctx).Execute()
+	var info *client.AppInfo
+	err := r.retry.withRetry(ctx, func(ctx context.Context) (resp *http.Response, err error) {
+		info, resp, err = r.client.InfoAPI.GetAppInfo(ctx).Execute()
+		return resp, err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -26,53 +44,251 @@ func (r *GreenEcolutionRepo) GetInfo(ctx context.Context) (*client.AppInfo, erro
 }
 
 func (r *GreenEcolutionRepo) GetTrees(ctx context.Context) ([]client.Tree, error) {
-	trees, _, err := r.client.TreeAPI.GetAllTrees(ctx).Execute()
+	var trees *client.TreeList
+	err := r.retry.withRetry(ctx, func(ctx context.Context) (resp *http.Response, err error) {
+		trees, resp, err = r.client.TreeAPI.GetAllTrees(ctx).Execute()
+		return resp, err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return trees.Data, nil
 }
 
-func (r *GreenEcolutionRepo) CreateTrees(ctx context.Context, trees []*entities.Tree) error {
-	for _, tree := range trees {
-		_, _, err := r.client.TreeAPI.CreateTree(ctx).Body(client.TreeCreate{
-			Description:  "Dieser Baum wurde von einem CSV-Import erstellt.",
-			Latitude:     float32(tree.Latitude),
-			Longitude:    float32(tree.Longitude),
-			PlantingYear: tree.PlantingYear,
-			Species:      tree.Species,
-			TreeNumber:   tree.Number,
-		}).Execute()
-		if err != nil {
-			return err
-		}
+// Position is where the backend stored a tree.
+type Position struct {
+	Latitude  float64
+	Longitude float64
+}
+
+func storedPosition(tree *client.Tree) Position {
+	return Position{
+		Latitude:  float64(tree.Latitude),
+		Longitude: float64(tree.Longitude),
 	}
-	return nil
-}
-
-func (r *GreenEcolutionRepo) UpdateTrees(ctx context.Context, trees []*entities.Tree) error {
-	for _, tree := range trees {
-		_, _, err := r.client.TreeAPI.UpdateTree(ctx, string(tree.TreeID)).Body(client.TreeUpdate{
-			Description:  "Dieser Baum wurde von einem CSV-Import aktualisiert.",
-			Latitude:     float32(tree.Latitude),
-			Longitude:    float32(tree.Longitude),
-			PlantingYear: tree.PlantingYear,
-			Species:      tree.Species,
-			TreeNumber:   tree.Number,
-		}).Execute()
-		if err != nil {
-			return err
-		}
+}
+
+// positionTolerance is how far in degrees the position of a tree in the
+// backend may be from the position it was sent with to still be the same tree.
+// The tree list of the generated client holds positions as float32, which
+// rounds them by up to 4e-6 degrees.
+const positionTolerance = 1e-5
+
+// ErrAmbiguousTree is returned when the backend has more than one tree that
+// could have been created from a tree that was sent. It needs a manual review,
+// linking either one could leave a duplicate behind.
+var ErrAmbiguousTree = errors.New("backend has several trees matching the tree")
+
+// FindCreatedTree looks for a tree in the backend that was created from the
+// given tree by an earlier call, which failed before its response arrived. A
+// tree matches if it has the same tree number, position, species and planting
+// year, the backend does not store the street. If one is found, its id is set
+// on the tree and its position returned. The backend cannot filter the tree
+// list, so all trees are fetched once per call and it should only be called if
+// a create may have been applied.
+func (r *GreenEcolutionRepo) FindCreatedTree(ctx context.Context, tree *entities.Tree) (Position, bool, error) {
+	trees, err := r.GetTrees(ctx)
+	if err != nil {
+		return Position{}, false, err
 	}
-	return nil
+
+	created, err := findCreatedTree(trees, tree)
+	if err != nil || created == nil {
+		return Position{}, false, err
+	}
+
+	backendID := created.Id
+	tree.BackendID = &backendID
+	return storedPosition(created), true, nil
 }
 
-func (r *GreenEcolutionRepo) DeleteTrees(ctx context.Context, treeIDs []entities.TreeID) error {
-	for _, treeID := range treeIDs {
-		_, err := r.client.TreeAPI.DeleteTree(ctx, string(treeID)).Execute()
-		if err != nil {
-			return err
+// findCreatedTree returns the tree that was created from the given tree, or nil
+// if there is none. Tree numbers repeat across streets, so trees elsewhere with
+// the same number are no candidates.
+func findCreatedTree(trees []client.Tree, tree *entities.Tree) (*client.Tree, error) {
+	var matches []*client.Tree
+	for idx := range trees {
+		candidate := &trees[idx]
+		if candidate.TreeNumber == tree.Number &&
+			candidate.Species == tree.Species &&
+			candidate.PlantingYear == tree.PlantingYear &&
+			sentAt(tree, storedPosition(candidate)) {
+			matches = append(matches, candidate)
 		}
 	}
-	return nil
+
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, errors.Wrapf(ErrAmbiguousTree, "%d trees with tree number %q at %.6f, %.6f", len(matches), tree.Number, tree.Latitude, tree.Longitude)
+	}
+}
+
+// sentAt tells whether the tree was sent with the stored position.
+func sentAt(tree *entities.Tree, stored Position) bool {
+	return math.Abs(tree.Latitude-stored.Latitude) <= positionTolerance &&
+		math.Abs(tree.Longitude-stored.Longitude) <= positionTolerance
+}
+
+// CreateTree creates the tree in the backend and sets the id the backend
+// assigned on the tree. It returns the position the backend stored. Before a
+// create that may have been applied is sent again, FindCreatedTree looks for the
+// tree in case the failed call created it. Every check fetches the tree list
+// once, the backend cannot filter it by tree number.
+func (r *GreenEcolutionRepo) CreateTree(ctx context.Context, tree *entities.Tree) (Position, error) {
+	body := client.TreeCreate{
+		Description:  "Dieser Baum wurde von einem CSV-Import erstellt.",
+		PlantingYear: tree.PlantingYear,
+		Species:      tree.Species,
+		TreeNumber:   tree.Number,
+	}
+
+	var created *treeResponse
+	var found Position
+	linked := false
+	err := r.retry.withRetryUnlessApplied(ctx, func(ctx context.Context) (resp *http.Response, err error) {
+		created, resp, err = r.sendTree(ctx, http.MethodPost, "/v1/tree", body, tree)
+		return resp, err
+	}, func(ctx context.Context) (bool, error) {
+		var err error
+		found, linked, err = r.FindCreatedTree(ctx, tree)
+		return linked, err
+	})
+	if err != nil {
+		return Position{}, err
+	}
+
+	if linked {
+		// a failed attempt created the tree, FindCreatedTree has set its id
+		return found, nil
+	}
+
+	backendID := created.ID
+	tree.BackendID = &backendID
+	return created.position(), nil
+}
+
+// UpdateTree updates the tree in the backend and returns the position the
+// backend stored.
+func (r *GreenEcolutionRepo) UpdateTree(ctx context.Context, tree *entities.Tree) (Position, error) {
+	backendID, err := backendTreeID(tree)
+	if err != nil {
+		return Position{}, err
+	}
+
+	body := client.TreeUpdate{
+		Description:  "Dieser Baum wurde von einem CSV-Import aktualisiert.",
+		PlantingYear: tree.PlantingYear,
+		Species:      tree.Species,
+		TreeNumber:   tree.Number,
+	}
+
+	var updated *treeResponse
+	err = r.retry.withRetry(ctx, func(ctx context.Context) (resp *http.Response, err error) {
+		updated, resp, err = r.sendTree(ctx, http.MethodPut, "/v1/tree/"+backendID, body, tree)
+		return resp, err
+	})
+	if err != nil {
+		return Position{}, err
+	}
+
+	return updated.position(), nil
+}
+
+// treeResponse is the part of the tree the backend answers with that is
+// needed after a create or update, with the position as float64.
+type treeResponse struct {
+	ID        int32   `json:"id"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (t *treeResponse) position() Position {
+	return Position{Latitude: t.Latitude, Longitude: t.Longitude}
+}
+
+// sendTree sends a tree create or update to the backend. The generated models
+// hold positions as float32, which moves trees by up to 0.2 m, so the body is
+// encoded from the model with the position of the tree as float64 and the
+// response is decoded the same way. The request is sent with the HTTP client
+// of the configuration, which authorizes it.
+func (r *GreenEcolutionRepo) sendTree(ctx context.Context, method, path string, model any, tree *entities.Tree) (*treeResponse, *http.Response, error) {
+	data, err := json.Marshal(model)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode tree")
+	}
+	var body map[string]any
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode tree")
+	}
+	body["latitude"] = tree.Latitude
+	body["longitude"] = tree.Longitude
+	if data, err = json.Marshal(body); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode tree")
+	}
+
+	if len(r.cfg.Servers) == 0 {
+		return nil, nil, errors.New("no backend server configured")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.cfg.Servers[0].URL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := r.cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, resp, errors.Errorf("backend responded with %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	var stored treeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stored); err != nil {
+		return nil, resp, errors.Wrap(err, "failed to decode tree")
+	}
+	return &stored, resp, nil
+}
+
+// DeleteTree deletes the tree in the backend. A tree the backend does not know
+// counts as deleted, an earlier attempt whose response was lost may have
+// deleted it.
+func (r *GreenEcolutionRepo) DeleteTree(ctx context.Context, tree *entities.Tree) error {
+	backendID, err := backendTreeID(tree)
+	if err != nil {
+		return err
+	}
+
+	var resp *http.Response
+	err = r.retry.withRetry(ctx, func(ctx context.Context) (*http.Response, error) {
+		var err error
+		resp, err = r.client.TreeAPI.DeleteTree(ctx, backendID).Execute()
+		return resp, err
+	})
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		slog.Info("Tree was already deleted in the backend", "tree", tree.TreeID, "backend_id", backendID)
+		return nil
+	}
+	return err
+}
+
+var ErrMissingBackendID = errors.New("tree has no backend id")
+
+func backendTreeID(tree *entities.Tree) (string, error) {
+	if tree.BackendID == nil {
+		return "", errors.Wrapf(ErrMissingBackendID, "tree %d", tree.TreeID)
+	}
+	return strconv.Itoa(int(*tree.BackendID)), nil
 }
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/green-ecolution/green-ecolution-backend/client"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+	"github.com/pkg/errors"
+)
+
+func TestFindCreatedTree(t *testing.T) {
+	tree := &entities.Tree{Number: "12", Species: "Tilia cordata", PlantingYear: 2010, Latitude: 54.7812345, Longitude: 9.4312345}
+	sent := client.Tree{Id: 1, TreeNumber: "12", Species: "Tilia cordata", PlantingYear: 2010, Latitude: 54.7812345, Longitude: 9.4312345}
+	with := func(id int32, change func(*client.Tree)) client.Tree {
+		candidate := sent
+		candidate.Id = id
+		change(&candidate)
+		return candidate
+	}
+	otherStreet := with(2, func(c *client.Tree) { c.Latitude, c.Longitude = 54.7901, 9.4402 })
+
+	tests := []struct {
+		name      string
+		trees     []client.Tree
+		want      int32
+		ambiguous bool
+	}{
+		{name: "no trees"},
+		{name: "created", trees: []client.Tree{sent}, want: 1},
+		{name: "same number in another street", trees: []client.Tree{otherStreet}},
+		{name: "created next to the same number in another street", trees: []client.Tree{otherStreet, sent}, want: 1},
+		{name: "other species", trees: []client.Tree{with(3, func(c *client.Tree) { c.Species = "Acer platanoides" })}},
+		{name: "other planting year", trees: []client.Tree{with(3, func(c *client.Tree) { c.PlantingYear = 2011 })}},
+		{name: "other number", trees: []client.Tree{with(3, func(c *client.Tree) { c.TreeNumber = "13" })}},
+		{name: "two matching trees", trees: []client.Tree{sent, with(3, func(*client.Tree) {})}, ambiguous: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created, err := findCreatedTree(tt.trees, tree)
+			if tt.ambiguous {
+				if !errors.Is(err, ErrAmbiguousTree) {
+					t.Fatalf("expected ErrAmbiguousTree, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got int32
+			if created != nil {
+				got = created.Id
+			}
+			if got != tt.want {
+				t.Errorf("found tree %d, expected %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+)
+
+// sqliteTimeFormat matches the format of CURRENT_TIMESTAMP and datetime('now'),
+// so timestamps can be compared as strings.
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+const importSummaryQuery = `SELECT i.id, i.created_at, i.user_id, i.status, i.finished_at, i.error, i.source_epsg, i.detected_epsg,
+  i.transformation, i.transformation_accuracy,
+  COUNT(CASE WHEN o.operation = 'create' AND o.status = 'applied' THEN 1 END) AS created_count,
+  COUNT(CASE WHEN o.operation = 'update' AND o.status = 'applied' THEN 1 END) AS updated_count,
+  COUNT(CASE WHEN o.operation = 'delete' AND o.status = 'applied' THEN 1 END) AS deleted_count,
+  COUNT(CASE WHEN o.status IN ('failed', 'pending') THEN 1 END) AS failed_count,
+  COUNT(CASE WHEN o.status = 'compensated' THEN 1 END) AS compensated_count
+FROM imports i
+LEFT JOIN import_operations o ON o.import_id = i.id`
+
+// ListImports returns one page of the imports matching the filter, newest
+// first, together with the number of all matching imports.
+func (r *ImportRepositoryDB) ListImports(ctx context.Context, filter entities.ImportFilter) ([]entities.ImportSummary, int, error) {
+	where, args := importFilterClause(filter)
+
+	var total int
+	if err := r.db.GetContext(ctx, &total, "SELECT COUNT(*) FROM imports i"+where, args...); err != nil {
+		return nil, 0, err
+	}
+
+	query := importSummaryQuery + where + " GROUP BY i.id ORDER BY i.created_at DESC, i.id DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	imports := make([]entities.ImportSummary, 0)
+	if err := r.db.SelectContext(ctx, &imports, query, args...); err != nil {
+		return nil, 0, err
+	}
+
+	return imports, total, nil
+}
+
+func (r *ImportRepositoryDB) GetImportSummary(ctx context.Context, id entities.ImportID) (*entities.ImportSummary, error) {
+	var summary entities.ImportSummary
+	if err := r.db.GetContext(ctx, &summary, importSummaryQuery+" WHERE i.id = ? GROUP BY i.id", id); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+func importFilterClause(filter entities.ImportFilter) (string, []any) {
+	var conditions []string
+	var args []any
+
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "i.created_at >= ?")
+		args = append(args, filter.From.UTC().Format(sqliteTimeFormat))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "i.created_at < ?")
+		args = append(args, filter.To.UTC().Format(sqliteTimeFormat))
+	}
+	if filter.UserID != "" {
+		conditions = append(conditions, "i.user_id = ?")
+		args = append(args, filter.UserID)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+const laterImportsQuery = `SELECT DISTINCT t.tree_id, t.import_id FROM (
+  SELECT tree_id, import_id FROM tree_import
+  UNION
+  SELECT tree_id, import_id FROM import_operations WHERE status = 'applied' AND tree_id IS NOT NULL
+) t
+JOIN imports i ON i.id = t.import_id
+WHERE t.import_id > ? AND i.status != 'rolled_back'
+  AND t.tree_id IN (SELECT tree_id FROM import_operations WHERE import_id = ? AND status = 'applied' AND tree_id IS NOT NULL)
+ORDER BY t.tree_id, t.import_id`
+
+// GetLaterImportConflicts returns the trees the import applied changes to
+// that were touched again by a later import that was not rolled back.
+func (r *ImportRepositoryDB) GetLaterImportConflicts(ctx context.Context, importID entities.ImportID) ([]entities.TreeConflict, error) {
+	conflicts := make([]entities.TreeConflict, 0)
+	if err := r.db.SelectContext(ctx, &conflicts, laterImportsQuery, importID, importID); err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
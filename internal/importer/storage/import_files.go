@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+	"github.com/pkg/errors"
+)
+
+const compressionGzip = "gzip"
+
+// importFileRow is the stored form of an entities.ImportFile, the content is
+// kept compressed.
+type importFileRow struct {
+	entities.ImportFile
+	Compression string `db:"compression"`
+	Data        []byte `db:"data"`
+}
+
+const (
+	saveImportFileQuery = "INSERT INTO import_files (import_id, filename, sha256, encoding, size, compression, data) VALUES (:import_id, :filename, :sha256, :encoding, :size, :compression, :data)"
+	getImportFileQuery  = "SELECT * FROM import_files WHERE import_id = ?"
+)
+
+// SaveImportFile stores the uploaded file of an import. The file is compressed
+// while it is uploaded, so only its compressed content is stored.
+func (r *ImportRepositoryDB) SaveImportFile(ctx context.Context, file *entities.ImportFile) error {
+	_, err := r.db.NamedExecContext(ctx, saveImportFileQuery, importFileRow{
+		ImportFile:  *file,
+		Compression: compressionGzip,
+		Data:        file.Compressed,
+	})
+	return err
+}
+
+// GetImportFile returns the uploaded file of an import. The decompressed
+// content is checked against the stored checksum.
+func (r *ImportRepositoryDB) GetImportFile(ctx context.Context, importID entities.ImportID) (*entities.ImportFile, error) {
+	var row importFileRow
+	if err := r.db.GetContext(ctx, &row, getImportFileQuery, importID); err != nil {
+		return nil, err
+	}
+
+	if row.Compression != compressionGzip {
+		return nil, errors.Errorf("unknown compression %q of import file %d", row.Compression, importID)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(row.Data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decompress import file %d", importID)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decompress import file %d", importID)
+	}
+
+	checksum := sha256.Sum256(content)
+	if hex.EncodeToString(checksum[:]) != row.SHA256 {
+		return nil, errors.Errorf("checksum mismatch of import file %d", importID)
+	}
+
+	file := row.ImportFile
+	file.Content = content
+	return &file, nil
+}
@@ -0,0 +1,82 @@
+-- +goose Up
+-- SQLite only auto-assigns ids for columns declared exactly as INTEGER PRIMARY KEY,
+-- so the SERIAL columns of the initial migration never received an id.
+CREATE TABLE imports_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  user_id VARCHAR(255),
+  raw_csv TEXT
+);
+
+INSERT INTO imports_new (created_at, user_id, raw_csv)
+SELECT created_at, user_id, raw_csv FROM imports;
+
+CREATE TABLE trees_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  tree_number VARCHAR(255) NOT NULL DEFAULT '',
+  species VARCHAR(255) NOT NULL DEFAULT '',
+  area VARCHAR(255) NOT NULL DEFAULT '',
+  planting_year INT NOT NULL DEFAULT 0,
+  street VARCHAR(255) NOT NULL DEFAULT '',
+  latitude FLOAT NOT NULL DEFAULT 0,
+  longitude FLOAT NOT NULL DEFAULT 0
+);
+
+INSERT INTO trees_new (created_at, updated_at, tree_number, species, area, planting_year, street, latitude, longitude)
+SELECT created_at, updated_at, COALESCE(tree_number, ''), species, COALESCE(area, ''), COALESCE(planting_year, 0), COALESCE(street, ''), COALESCE(latitude, 0), COALESCE(longitude, 0) FROM trees;
+
+-- The old link table only ever referenced NULL ids and cannot be migrated.
+DROP TABLE IF EXISTS tree_import;
+DROP TABLE trees;
+DROP TABLE imports;
+ALTER TABLE trees_new RENAME TO trees;
+ALTER TABLE imports_new RENAME TO imports;
+
+CREATE TABLE tree_import (
+  tree_id INTEGER NOT NULL,
+  import_id INTEGER NOT NULL,
+  PRIMARY KEY (tree_id, import_id),
+  FOREIGN KEY (tree_id) REFERENCES trees(id),
+  FOREIGN KEY (import_id) REFERENCES imports(id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS tree_import;
+
+CREATE TABLE trees_old (
+  id SERIAL PRIMARY KEY,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  tree_number VARCHAR(255),
+  species VARCHAR(255) NOT NULL DEFAULT '',
+  area VARCHAR(255),
+  planting_year INT,
+  street VARCHAR(255),
+  latitude FLOAT,
+  longitude FLOAT
+);
+
+INSERT INTO trees_old SELECT * FROM trees;
+DROP TABLE trees;
+ALTER TABLE trees_old RENAME TO trees;
+
+CREATE TABLE imports_old (
+  id SERIAL PRIMARY KEY,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  user_id VARCHAR(255),
+  raw_csv TEXT
+);
+
+INSERT INTO imports_old SELECT * FROM imports;
+DROP TABLE imports;
+ALTER TABLE imports_old RENAME TO imports;
+
+CREATE TABLE tree_import (
+  tree_id INT,
+  import_id INT,
+  PRIMARY KEY (tree_id, import_id),
+  FOREIGN KEY (tree_id) REFERENCES trees(id),
+  FOREIGN KEY (import_id) REFERENCES imports(id)
+);
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE trees ADD COLUMN backend_id INTEGER;
+CREATE UNIQUE INDEX IF NOT EXISTS trees_backend_id_idx ON trees(backend_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS trees_backend_id_idx;
+ALTER TABLE trees DROP COLUMN backend_id;
@@ -0,0 +1,27 @@
+-- +goose Up
+ALTER TABLE imports ADD COLUMN status VARCHAR(32) NOT NULL DEFAULT 'succeeded';
+ALTER TABLE imports ADD COLUMN finished_at TIMESTAMP;
+ALTER TABLE imports ADD COLUMN error TEXT;
+
+CREATE TABLE IF NOT EXISTS import_operations (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  import_id INTEGER NOT NULL,
+  tree_id INTEGER,
+  backend_id INTEGER,
+  operation VARCHAR(16) NOT NULL,
+  status VARCHAR(16) NOT NULL,
+  previous TEXT,
+  last_error TEXT,
+  FOREIGN KEY (import_id) REFERENCES imports(id)
+);
+
+CREATE INDEX IF NOT EXISTS import_operations_import_id_idx ON import_operations(import_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS import_operations_import_id_idx;
+DROP TABLE IF EXISTS import_operations;
+ALTER TABLE imports DROP COLUMN error;
+ALTER TABLE imports DROP COLUMN finished_at;
+ALTER TABLE imports DROP COLUMN status;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE import_operations ADD COLUMN payload TEXT;
+ALTER TABLE import_operations ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE import_operations DROP COLUMN attempts;
+ALTER TABLE import_operations DROP COLUMN payload;
@@ -0,0 +1,5 @@
+-- +goose Up
+CREATE INDEX IF NOT EXISTS imports_created_at_idx ON imports(created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS imports_created_at_idx;
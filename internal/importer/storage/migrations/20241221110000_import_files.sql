@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS import_files (
+  import_id INTEGER PRIMARY KEY,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  filename VARCHAR(255) NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  encoding VARCHAR(32) NOT NULL,
+  size INTEGER NOT NULL,
+  compression VARCHAR(16) NOT NULL,
+  data BLOB NOT NULL,
+  FOREIGN KEY (import_id) REFERENCES imports(id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS import_files;
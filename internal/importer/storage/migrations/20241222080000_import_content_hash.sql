@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE imports ADD COLUMN content_hash CHAR(64);
+CREATE INDEX IF NOT EXISTS imports_content_hash_idx ON imports(content_hash);
+
+-- +goose Down
+DROP INDEX IF EXISTS imports_content_hash_idx;
+ALTER TABLE imports DROP COLUMN content_hash;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE import_operations ADD COLUMN diff TEXT;
+
+-- +goose Down
+ALTER TABLE import_operations DROP COLUMN diff;
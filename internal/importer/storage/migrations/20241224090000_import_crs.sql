@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE imports ADD COLUMN source_epsg INTEGER;
+ALTER TABLE imports ADD COLUMN detected_epsg INTEGER;
+
+-- +goose Down
+ALTER TABLE imports DROP COLUMN detected_epsg;
+ALTER TABLE imports DROP COLUMN source_epsg;
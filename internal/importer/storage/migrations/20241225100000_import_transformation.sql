@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE imports ADD COLUMN transformation TEXT;
+ALTER TABLE imports ADD COLUMN transformation_accuracy REAL;
+
+-- +goose Down
+ALTER TABLE imports DROP COLUMN transformation_accuracy;
+ALTER TABLE imports DROP COLUMN transformation;
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	addOperationQuery    = "INSERT INTO import_operations (import_id, tree_id, backend_id, operation, status, payload, previous, diff, attempts, last_error) VALUES (:import_id, :tree_id, :backend_id, :operation, :status, :payload, :previous, :diff, :attempts, :last_error)"
+	updateOperationQuery = "UPDATE import_operations SET tree_id = :tree_id, backend_id = :backend_id, status = :status, attempts = :attempts, last_error = :last_error, updated_at = datetime('now') WHERE id = :id"
+	getOperationsQuery   = "SELECT * FROM import_operations WHERE import_id = ? ORDER BY id"
+)
+
+func (r *ImportRepositoryDB) AddOperation(ctx context.Context, op *entities.ImportOperation) error {
+	return addOperation(ctx, r.db, op)
+}
+
+func (r *ImportRepositoryTx) AddOperation(ctx context.Context, op *entities.ImportOperation) error {
+	return addOperation(ctx, r.db, op)
+}
+
+func addOperation(ctx context.Context, db sqlx.ExtContext, op *entities.ImportOperation) error {
+	res, err := sqlx.NamedExecContext(ctx, db, addOperationQuery, op)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	op.ID = entities.ImportOperationID(id)
+
+	return nil
+}
+
+func (r *ImportRepositoryDB) UpdateOperation(ctx context.Context, op *entities.ImportOperation) error {
+	_, err := sqlx.NamedExecContext(ctx, r.db, updateOperationQuery, op)
+	return err
+}
+
+func (r *ImportRepositoryTx) UpdateOperation(ctx context.Context, op *entities.ImportOperation) error {
+	_, err := sqlx.NamedExecContext(ctx, r.db, updateOperationQuery, op)
+	return err
+}
+
+func (r *ImportRepositoryDB) GetOperations(ctx context.Context, importID entities.ImportID) ([]entities.ImportOperation, error) {
+	var ops []entities.ImportOperation
+	err := r.db.SelectContext(ctx, &ops, getOperationsQuery, importID)
+	return ops, err
+}
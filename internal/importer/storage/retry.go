@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryConfig configures how calls to the Green Ecolution backend are retried.
+// Only rate limited requests (429), server errors (5xx), timeouts and failed
+// connections are retried.
+type RetryConfig struct {
+	// MaxAttempts is the number of times a call is made before giving up.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. It doubles with every
+	// further retry up to MaxBackoff and is randomized by up to a half.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// RequestTimeout limits a single attempt. Zero disables the limit.
+	RequestTimeout time.Duration
+}
+
+var DefaultRetryConfig = RetryConfig{
+	MaxAttempts:    5,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	RequestTimeout: 30 * time.Second,
+}
+
+func (c RetryConfig) Validate() error {
+	if c.MaxAttempts < 1 {
+		return errors.Errorf("invalid number of attempts %d, expected at least 1", c.MaxAttempts)
+	}
+
+	if c.InitialBackoff < 0 || c.MaxBackoff < c.InitialBackoff {
+		return errors.Errorf("invalid backoff %s to %s", c.InitialBackoff, c.MaxBackoff)
+	}
+
+	if c.RequestTimeout < 0 {
+		return errors.Errorf("invalid request timeout %s", c.RequestTimeout)
+	}
+
+	return nil
+}
+
+// withRetry runs call until it succeeds, fails with an error that is not worth
+// retrying or the attempts are used up. A Retry-After header sent by the
+// backend takes precedence over the computed backoff.
+func (c RetryConfig) withRetry(ctx context.Context, call func(ctx context.Context) (*http.Response, error)) error {
+	return c.run(ctx, call, nil)
+}
+
+// withRetryUnlessApplied retries a call that is not idempotent, like creating
+// a tree. The call is repeated if the backend provably did not apply it: the
+// connection failed before the request was sent, or the backend answered 429
+// or 503 with a Retry-After header. After other failures, like timeouts or
+// server errors, the backend may have applied the request anyway. Then applied
+// is asked after the backoff and the call is only repeated if it reports false.
+func (c RetryConfig) withRetryUnlessApplied(ctx context.Context, call func(ctx context.Context) (*http.Response, error), applied func(ctx context.Context) (bool, error)) error {
+	return c.run(ctx, call, applied)
+}
+
+func (c RetryConfig) run(ctx context.Context, call func(ctx context.Context) (*http.Response, error), applied func(ctx context.Context) (bool, error)) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var resp *http.Response
+		resp, err = c.attempt(ctx, call)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= c.MaxAttempts || ctx.Err() != nil || !retryable(resp, err) {
+			break
+		}
+
+		wait := c.backoff(attempt)
+		if retryAfter, ok := parseRetryAfter(resp); ok {
+			wait = retryAfter
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrap(ctx.Err(), err.Error())
+		case <-timer.C:
+		}
+
+		if applied != nil && !notSent(resp, err) {
+			ok, checkErr := applied(ctx)
+			if checkErr != nil {
+				return errors.Wrapf(err, "failed to check whether the backend applied the request: %v", checkErr)
+			}
+			if ok {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+func (c RetryConfig) attempt(ctx context.Context, call func(ctx context.Context) (*http.Response, error)) (*http.Response, error) {
+	if c.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.RequestTimeout)
+		defer cancel()
+	}
+
+	return call(ctx)
+}
+
+// backoff returns the wait before the given retry, chosen randomly between
+// half and the full exponential backoff so concurrent workers do not retry in
+// lockstep.
+func (c RetryConfig) backoff(attempt int) time.Duration {
+	backoff := c.InitialBackoff
+	for i := 1; i < attempt && backoff < c.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, c.MaxBackoff)
+
+	if backoff <= 0 {
+		return 0
+	}
+
+	half := backoff / 2
+	return half + rand.N(backoff-half+1)
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if resp != nil {
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || notSent(resp, err) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// notSent tells whether a failed request provably was not applied by the
+// backend, so it is safe to send it again even if it is not idempotent.
+func notSent(resp *http.Response, err error) bool {
+	if resp != nil {
+		_, ok := parseRetryAfter(resp)
+		return ok && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable)
+	}
+
+	// the connection could not be established, nothing was sent
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// parseRetryAfter reads the Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+
+	return 0, false
+}
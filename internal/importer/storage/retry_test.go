@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRetryConfigBackoff(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	tests := []struct {
+		attempt int
+		// full is the backoff before randomization, the wait is at least half of it
+		full time.Duration
+	}{
+		{attempt: 1, full: 100 * time.Millisecond},
+		{attempt: 2, full: 200 * time.Millisecond},
+		{attempt: 3, full: 400 * time.Millisecond},
+		{attempt: 4, full: 800 * time.Millisecond},
+		{attempt: 5, full: time.Second},
+		{attempt: 30, full: time.Second},
+	}
+	for _, tt := range tests {
+		for range 100 {
+			if wait := cfg.backoff(tt.attempt); wait < tt.full/2 || wait > tt.full {
+				t.Fatalf("attempt %d: waits %s, expected %s to %s", tt.attempt, wait, tt.full/2, tt.full)
+			}
+		}
+	}
+
+	if wait := (RetryConfig{}).backoff(3); wait != 0 {
+		t.Errorf("waits %s without backoff", wait)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+		// slack allows for the time passing while an HTTP date is parsed
+		slack time.Duration
+	}{
+		{name: "missing"},
+		{name: "seconds", value: "120", want: 2 * time.Minute, wantOK: true},
+		{name: "zero seconds", value: "0", wantOK: true},
+		{name: "negative seconds", value: "-1"},
+		{name: "HTTP date", value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), want: time.Minute, wantOK: true, slack: 2 * time.Second},
+		{name: "HTTP date in the past", value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), wantOK: true},
+		{name: "invalid", value: "soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.value != "" {
+				resp.Header.Set("Retry-After", tt.value)
+			}
+
+			got, ok := parseRetryAfter(resp)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %t, expected %t", ok, tt.wantOK)
+			}
+			if got > tt.want || got < tt.want-tt.slack {
+				t.Errorf("got %s, expected %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := parseRetryAfter(nil); ok {
+		t.Error("expected no Retry-After without a response")
+	}
+}
+
+func TestNotSent(t *testing.T) {
+	response := func(status int, retryAfter string) *http.Response {
+		resp := &http.Response{StatusCode: status, Header: http.Header{}}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name string
+		resp *http.Response
+		err  error
+		want bool
+	}{
+		{name: "rate limited", resp: response(http.StatusTooManyRequests, "1"), want: true},
+		{name: "rate limited without Retry-After", resp: response(http.StatusTooManyRequests, "")},
+		{name: "unavailable", resp: response(http.StatusServiceUnavailable, "1"), want: true},
+		{name: "unavailable without Retry-After", resp: response(http.StatusServiceUnavailable, "")},
+		{name: "server error", resp: response(http.StatusInternalServerError, "1")},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}},
+		{name: "timeout", err: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.err
+			if err == nil {
+				err = errors.New("request failed")
+			}
+			if got := notSent(tt.resp, err); got != tt.want {
+				t.Errorf("got %t, expected %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// backendResponse writes a single response of a scripted backend.
+type backendResponse func(w http.ResponseWriter, r *http.Request)
+
+// scriptedBackend answers the requests with the given responses in order and
+// succeeds once they are used up.
+type scriptedBackend struct {
+	server    *httptest.Server
+	responses []backendResponse
+	requests  atomic.Int32
+}
+
+func newScriptedBackend(t *testing.T, responses ...backendResponse) *scriptedBackend {
+	t.Helper()
+
+	b := &scriptedBackend{responses: responses}
+	b.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if idx := int(b.requests.Add(1)) - 1; idx < len(b.responses) {
+			b.responses[idx](w, r)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(b.server.Close)
+	return b
+}
+
+func (b *scriptedBackend) call(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.server.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.server.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp, errors.Errorf("backend responded with %s", resp.Status)
+	}
+	return resp, nil
+}
+
+func status(code int, retryAfter string) backendResponse {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(code)
+	}
+}
+
+// hang answers only after the request was cancelled by its timeout.
+func hang(w http.ResponseWriter, r *http.Request) {
+	<-r.Context().Done()
+}
+
+func TestRetryConfigWithRetry(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond, RequestTimeout: 100 * time.Millisecond}
+
+	tests := []struct {
+		name         string
+		responses    []backendResponse
+		wantErr      bool
+		wantRequests int32
+	}{
+		{name: "success", wantRequests: 1},
+		{name: "rate limited with Retry-After", responses: []backendResponse{status(http.StatusTooManyRequests, "0")}, wantRequests: 2},
+		{name: "unavailable", responses: []backendResponse{status(http.StatusServiceUnavailable, "")}, wantRequests: 2},
+		{name: "timeout", responses: []backendResponse{hang}, wantRequests: 2},
+		{name: "client error is not retried", responses: []backendResponse{status(http.StatusBadRequest, "")}, wantErr: true, wantRequests: 1},
+		{
+			name: "attempts used up",
+			responses: []backendResponse{
+				status(http.StatusBadGateway, ""), status(http.StatusBadGateway, ""), status(http.StatusBadGateway, "")},
+			wantErr:      true,
+			wantRequests: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newScriptedBackend(t, tt.responses...)
+
+			err := cfg.withRetry(context.Background(), backend.call)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, expected an error %t", err, tt.wantErr)
+			}
+			if got := backend.requests.Load(); got != tt.wantRequests {
+				t.Errorf("sent %d requests, expected %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryConfigWithRetryUnlessApplied(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond, RequestTimeout: 100 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		response backendResponse
+		// applied is what the check reports after a failure
+		applied      bool
+		wantChecks   int
+		wantRequests int32
+	}{
+		{name: "rate limited is sent again without a check", response: status(http.StatusTooManyRequests, "0"), wantRequests: 2},
+		{name: "unavailable with Retry-After is sent again without a check", response: status(http.StatusServiceUnavailable, "0"), wantRequests: 2},
+		{name: "applied after a timeout", response: hang, applied: true, wantChecks: 1, wantRequests: 1},
+		{name: "applied after a server error", response: status(http.StatusInternalServerError, ""), applied: true, wantChecks: 1, wantRequests: 1},
+		{name: "not applied after a server error", response: status(http.StatusInternalServerError, ""), wantChecks: 1, wantRequests: 2},
+		{name: "not applied after unavailable without Retry-After", response: status(http.StatusServiceUnavailable, ""), wantChecks: 1, wantRequests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newScriptedBackend(t, tt.response)
+
+			checks := 0
+			err := cfg.withRetryUnlessApplied(context.Background(), backend.call, func(ctx context.Context) (bool, error) {
+				checks++
+				return tt.applied, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checks != tt.wantChecks {
+				t.Errorf("checked %d times, expected %d", checks, tt.wantChecks)
+			}
+			if got := backend.requests.Load(); got != tt.wantRequests {
+				t.Errorf("sent %d requests, expected %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryConfigWithRetryUnlessAppliedCheckFails(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	backend := newScriptedBackend(t, status(http.StatusBadGateway, ""))
+
+	err := cfg.withRetryUnlessApplied(context.Background(), backend.call, func(ctx context.Context) (bool, error) {
+		return false, errors.New("tree list unavailable")
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := backend.requests.Load(); got != 1 {
+		t.Errorf("sent %d requests, expected the create to be sent once", got)
+	}
+}
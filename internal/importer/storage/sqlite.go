@@ -13,6 +13,7 @@ type ImportRepository interface {
 	GetAllTrees(ctx context.Context) ([]entities.Tree, error)
 	DeleteTreesByID(ctx context.Context, treeID []entities.TreeID) error
 	CreateTrees(ctx context.Context, trees []*entities.Tree) error
+	UpdateTrees(ctx context.Context, trees []*entities.Tree) error
 }
 
 type ImportRepositoryDB struct {
@@ -73,10 +74,11 @@ func (r *ImportRepositoryDB) WithTx(ctx context.Context, fn func(context.Context
 }
 
 const (
-	getAllQuery = "SELECT * FROM trees"
-	deleteQuery = "DELETE FROM trees WHERE tree_id IN (?)"
-	createQuery = "INSERT INTO trees (tree_number, species, area, planting_year, street, latitude, longitude) VALUES (:tree_number, :species, :area, :planting_year, :street, :latitude, :longitude)"
-	updateQuery = "UPDATE trees SET tree_number = :tree_number, species = :species, area = :area, planting_year = :planting_year, street = :street, latitude = :latitude, longitude = :longitude WHERE tree_id = :tree_id"
+	getAllQuery  = "SELECT * FROM trees"
+	deleteQuery  = "DELETE FROM trees WHERE id IN (?)"
+	createQuery  = "INSERT INTO trees (tree_number, species, area, planting_year, street, latitude, longitude, backend_id) VALUES (:tree_number, :species, :area, :planting_year, :street, :latitude, :longitude, :backend_id)"
+	restoreQuery = "INSERT OR REPLACE INTO trees (id, created_at, updated_at, tree_number, species, area, planting_year, street, latitude, longitude, backend_id) VALUES (:id, :created_at, datetime('now'), :tree_number, :species, :area, :planting_year, :street, :latitude, :longitude, :backend_id)"
+	updateQuery  = "UPDATE trees SET tree_number = :tree_number, species = :species, area = :area, planting_year = :planting_year, street = :street, latitude = :latitude, longitude = :longitude, updated_at = datetime('now') WHERE id = :id"
 )
 
 func (r *ImportRepositoryDB) GetAllTrees(ctx context.Context) ([]entities.Tree, error) {
@@ -96,65 +98,127 @@ func (r *ImportRepositoryTx) GetAllTrees(ctx context.Context) ([]entities.Tree,
 }
 
 func (r *ImportRepositoryDB) DeleteTreesByID(ctx context.Context, treeID []entities.TreeID) error {
-	_, err := r.db.ExecContext(ctx, deleteQuery, treeID)
-	return err
+	return deleteTreesByID(ctx, r.db, treeID)
 }
 
 func (r *ImportRepositoryTx) DeleteTreesByID(ctx context.Context, treeID []entities.TreeID) error {
-	_, err := r.db.ExecContext(ctx, deleteQuery, treeID)
+	return deleteTreesByID(ctx, r.db, treeID)
+}
+
+func deleteTreesByID(ctx context.Context, db sqlx.ExtContext, treeID []entities.TreeID) error {
+	if len(treeID) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In(deleteQuery, treeID)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, db.Rebind(query), args...)
 	return err
 }
 
 func (r *ImportRepositoryDB) CreateTrees(ctx context.Context, trees []*entities.Tree) error {
-	_, err := r.db.NamedExecContext(ctx, createQuery, trees)
-	return err
+	return createTrees(ctx, r.db, trees)
 }
 
 func (r *ImportRepositoryTx) CreateTrees(ctx context.Context, trees []*entities.Tree) error {
-	_, err := r.db.NamedExecContext(ctx, createQuery, trees)
-	return err
+	return createTrees(ctx, r.db, trees)
+}
+
+func createTrees(ctx context.Context, db sqlx.ExtContext, trees []*entities.Tree) error {
+	for _, tree := range trees {
+		res, err := sqlx.NamedExecContext(ctx, db, createQuery, tree)
+		if err != nil {
+			return err
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		tree.TreeID = entities.TreeID(id)
+	}
+
+	return nil
 }
 
 func (r *ImportRepositoryDB) UpdateTrees(ctx context.Context, trees []*entities.Tree) error {
-	_, err := r.db.NamedExecContext(ctx, updateQuery, trees)
-	return err
+	return updateTrees(ctx, r.db, trees)
 }
 
 func (r *ImportRepositoryTx) UpdateTrees(ctx context.Context, trees []*entities.Tree) error {
-	_, err := r.db.NamedExecContext(ctx, updateQuery, trees)
-	return err
+	return updateTrees(ctx, r.db, trees)
 }
 
-func (r *ImportRepositoryDB) AddImport(ctx context.Context, i entities.Import, treeIDs []entities.TreeID) error {
-	tx, err := r.db.BeginTxx(ctx, nil)
-	if err != nil {
-		return err
+func updateTrees(ctx context.Context, db sqlx.ExtContext, trees []*entities.Tree) error {
+	for _, tree := range trees {
+		if _, err := sqlx.NamedExecContext(ctx, db, updateQuery, tree); err != nil {
+			return err
+		}
 	}
 
-	importRow, err := tx.ExecContext(ctx, "INSERT INTO imports (created_at, user_id, raw_csv) VALUES (datetime('now'), ?, ?)", i.UserID, i.RawCSV)
-	if err != nil {
-		if err := tx.Rollback(); err != nil {
+	return nil
+}
+
+// RestoreTrees writes the trees with their original local ids, recreating
+// them if they were deleted.
+func (r *ImportRepositoryDB) RestoreTrees(ctx context.Context, trees []*entities.Tree) error {
+	for _, tree := range trees {
+		if _, err := sqlx.NamedExecContext(ctx, r.db, restoreQuery, tree); err != nil {
 			return err
 		}
-		return err
 	}
 
-	importID, err := importRow.LastInsertId()
+	return nil
+}
+
+func (r *ImportRepositoryDB) CreateImport(ctx context.Context, i entities.Import) (entities.ImportID, error) {
+	res, err := r.db.ExecContext(ctx, "INSERT INTO imports (created_at, user_id, raw_csv, status, content_hash, source_epsg, detected_epsg, transformation, transformation_accuracy) VALUES (datetime('now'), ?, ?, ?, ?, ?, ?, ?, ?)",
+		i.UserID, i.RawCSV, i.Status, i.ContentHash, i.SourceEPSG, i.DetectedEPSG, i.Transformation, i.TransformationAccuracy)
 	if err != nil {
-		if err := tx.Rollback(); err != nil {
-			return err
-		}
-		return err
+		return 0, err
 	}
 
-	for _, treeID := range treeIDs {
-		if _, err := tx.ExecContext(ctx, "INSERT INTO import_tree (import_id, tree_id) VALUES (?, ?)", importID, treeID); err != nil {
-			if err := tx.Rollback(); err != nil {
-				return err
-			}
-			return err
-		}
+	importID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
 	}
 
+	return entities.ImportID(importID), nil
+}
+
+func (r *ImportRepositoryDB) GetImport(ctx context.Context, id entities.ImportID) (*entities.Import, error) {
+	var i entities.Import
+	if err := r.db.GetContext(ctx, &i, "SELECT * FROM imports WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// FindImportByContentHash returns the latest succeeded import of a CSV file
+// with the given content hash.
+func (r *ImportRepositoryDB) FindImportByContentHash(ctx context.Context, contentHash string) (*entities.Import, error) {
+	var i entities.Import
+	if err := r.db.GetContext(ctx, &i, "SELECT * FROM imports WHERE content_hash = ? AND status = ? ORDER BY id DESC LIMIT 1", contentHash, entities.ImportStatusSucceeded); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (r *ImportRepositoryDB) FinishImport(ctx context.Context, id entities.ImportID, status entities.ImportStatus, importErr error) error {
+	var errMsg *string
+	if importErr != nil {
+		msg := importErr.Error()
+		errMsg = &msg
+	}
+
+	_, err := r.db.ExecContext(ctx, "UPDATE imports SET status = ?, error = COALESCE(?, error), finished_at = datetime('now') WHERE id = ?", status, errMsg, id)
+	return err
+}
+
+func (r *ImportRepositoryTx) LinkTree(ctx context.Context, importID entities.ImportID, treeID entities.TreeID) error {
+	_, err := r.db.ExecContext(ctx, "INSERT OR IGNORE INTO tree_import (import_id, tree_id) VALUES (?, ?)", importID, treeID)
 	return err
 }
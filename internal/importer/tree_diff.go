@@ -0,0 +1,50 @@
+package importer
+
+import (
+	"fmt"
+
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+)
+
+const (
+	FieldChangeSpecies     = "species"
+	FieldChangeNumber      = "tree_number"
+	FieldChangeStreet      = "street"
+	FieldChangeArea        = "area"
+	FieldChangeCoordinates = "coordinates"
+)
+
+// coordinateTolerance is the distance in metres below which coordinates count
+// as unchanged, so rounding in the transformation does not cause updates.
+const coordinateTolerance = 0.01
+
+// diffTrees returns the attributes that differ between the previously imported
+// tree and the tree from the CSV file.
+func diffTrees(existing, tree *entities.Tree) []entities.FieldChange {
+	changes := make([]entities.FieldChange, 0)
+
+	addChange := func(field, old, new string) {
+		if old != new {
+			changes = append(changes, entities.FieldChange{Field: field, Old: old, New: new})
+		}
+	}
+
+	addChange(FieldChangeSpecies, existing.Species, tree.Species)
+	addChange(FieldChangeNumber, existing.Number, tree.Number)
+	addChange(FieldChangeStreet, existing.Street, tree.Street)
+	addChange(FieldChangeArea, existing.Area, tree.Area)
+
+	if Distance(existing.Latitude, existing.Longitude, tree.Latitude, tree.Longitude) > coordinateTolerance {
+		changes = append(changes, entities.FieldChange{
+			Field: FieldChangeCoordinates,
+			Old:   formatCoordinates(existing),
+			New:   formatCoordinates(tree),
+		})
+	}
+
+	return changes
+}
+
+func formatCoordinates(tree *entities.Tree) string {
+	return fmt.Sprintf("%.7f, %.7f", tree.Latitude, tree.Longitude)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/auth"
+	"github.com/pkg/errors"
+)
+
+const userLocalsKey = "user"
+
+// authenticate verifies the bearer token the plugin UI was embedded with and
+// stores the user in the request locals. Without a verifier requests are not
+// authenticated.
+func (s *Server) authenticate(c *fiber.Ctx) error {
+	if s.cfg.verifier == nil {
+		return c.Next()
+	}
+
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok || strings.TrimSpace(token) == "" {
+		return fiber.NewError(fiber.StatusUnauthorized, "missing bearer token")
+	}
+
+	user, err := s.cfg.verifier.Verify(c.UserContext(), strings.TrimSpace(token))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+		}
+		return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+	}
+
+	c.Locals(userLocalsKey, user)
+	return c.Next()
+}
+
+// requireImportRole only lets users with the configured role change trees.
+func (s *Server) requireImportRole(c *fiber.Ctx) error {
+	if s.cfg.verifier == nil || s.cfg.verifier.RequiredRole() == "" {
+		return c.Next()
+	}
+
+	user := currentUser(c)
+	if user == nil || !user.HasRole(s.cfg.verifier.RequiredRole()) {
+		return fiber.NewError(fiber.StatusForbidden, "missing role "+s.cfg.verifier.RequiredRole())
+	}
+
+	return c.Next()
+}
+
+// currentUser returns the authenticated user of the request, or nil if
+// requests are not authenticated.
+func currentUser(c *fiber.Ctx) *auth.User {
+	user, _ := c.Locals(userLocalsKey).(*auth.User)
+	return user
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/auth"
+)
+
+type AuthHealthResponse struct {
+	State       auth.HealthState `json:"state"`
+	Error       *string          `json:"error"`
+	Expiry      *time.Time       `json:"expiry"`
+	RefreshedAt *time.Time       `json:"refreshed_at"`
+}
+
+type HealthResponse struct {
+	Status  auth.HealthState   `json:"status"`
+	Version string             `json:"version"`
+	Auth    AuthHealthResponse `json:"auth"`
+}
+
+// health reports whether the plugin can reach the backend. It responds with
+// 503 once the access token could not be refreshed.
+func (s *Server) health(c *fiber.Ctx) error {
+	response := HealthResponse{
+		Status:  auth.HealthStateHealthy,
+		Version: s.cfg.version,
+		Auth: AuthHealthResponse{
+			State: auth.HealthStateHealthy,
+		},
+	}
+
+	if s.cfg.tokenSource != nil {
+		health := s.cfg.tokenSource.Health()
+		response.Status = health.State
+		response.Auth = mapAuthHealth(health)
+	}
+
+	if response.Status != auth.HealthStateHealthy {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(response)
+	}
+
+	return c.JSON(response)
+}
+
+func mapAuthHealth(health auth.Health) AuthHealthResponse {
+	response := AuthHealthResponse{
+		State: health.State,
+	}
+
+	if health.Error != nil {
+		msg := health.Error.Error()
+		response.Error = &msg
+	}
+	if !health.Expiry.IsZero() {
+		response.Expiry = &health.Expiry
+	}
+	if !health.RefreshedAt.IsZero() {
+		response.RefreshedAt = &health.RefreshedAt
+	}
+
+	return response
+}
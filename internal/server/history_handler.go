@@ -0,0 +1,176 @@
+package server
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/importer"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/utils"
+	"github.com/pkg/errors"
+)
+
+const dateLayout = "2006-01-02"
+
+type ImportHistoryEntryResponse struct {
+	ID               entities.ImportID     `json:"id"`
+	UserID           entities.UserID       `json:"user_id"`
+	Status           entities.ImportStatus `json:"status"`
+	CreatedAt        time.Time             `json:"created_at"`
+	FinishedAt       *time.Time            `json:"finished_at"`
+	DurationMillis   int64                 `json:"duration_ms"`
+	Error            *string               `json:"error"`
+	SourceEPSG       *int                  `json:"source_epsg"`
+	DetectedEPSG     *int                  `json:"detected_epsg"`
+	Transformation   *string               `json:"transformation"`
+	Accuracy         *float64              `json:"transformation_accuracy_m"`
+	CreatedCount     int                   `json:"created_count"`
+	UpdatedCount     int                   `json:"updated_count"`
+	DeletedCount     int                   `json:"deleted_count"`
+	FailedCount      int                   `json:"failed_count"`
+	CompensatedCount int                   `json:"compensated_count"`
+}
+
+type ImportHistoryResponse struct {
+	Imports []ImportHistoryEntryResponse `json:"imports"`
+	Total   int                          `json:"total"`
+	Limit   int                          `json:"limit"`
+	Offset  int                          `json:"offset"`
+}
+
+type ImportChangeResponse struct {
+	OperationID entities.ImportOperationID `json:"operation_id"`
+	Operation   entities.OperationType     `json:"operation"`
+	Status      entities.OperationStatus   `json:"status"`
+	Attempts    int32                      `json:"attempts"`
+	Tree        *ImportTreeResponse        `json:"tree"`
+	Previous    *ImportTreeResponse        `json:"previous"`
+	Diff        []FieldChangeResponse      `json:"diff"`
+	Error       *string                    `json:"error"`
+}
+
+type ImportDetailsResponse struct {
+	ImportHistoryEntryResponse
+	Changes []ImportChangeResponse `json:"changes"`
+}
+
+func (s *Server) listImports(c *fiber.Ctx) error {
+	if s.cfg.importService == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "import service is not available")
+	}
+
+	filter, err := importFilter(c)
+	if err != nil {
+		return err
+	}
+
+	page, err := s.cfg.importService.ListImports(c.UserContext(), filter)
+	if err != nil {
+		return errors.Wrap(err, "failed to list imports")
+	}
+
+	return c.JSON(ImportHistoryResponse{
+		Imports: utils.Map(page.Imports, mapImportHistoryEntry),
+		Total:   page.Total,
+		Limit:   page.Limit,
+		Offset:  page.Offset,
+	})
+}
+
+func (s *Server) getImport(c *fiber.Ctx) error {
+	if s.cfg.importService == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "import service is not available")
+	}
+
+	importID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid import id")
+	}
+
+	details, err := s.cfg.importService.GetImportDetails(c.UserContext(), entities.ImportID(importID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "import not found")
+		}
+		return errors.Wrap(err, "failed to load import")
+	}
+
+	return c.JSON(ImportDetailsResponse{
+		ImportHistoryEntryResponse: mapImportHistoryEntry(details.Summary),
+		Changes: utils.Map(details.Changes, func(change importer.ImportChange) ImportChangeResponse {
+			return ImportChangeResponse{
+				OperationID: change.OperationID,
+				Operation:   change.Operation,
+				Status:      change.Status,
+				Attempts:    change.Attempts,
+				Tree:        mapOptionalImportTree(change.Tree),
+				Previous:    mapOptionalImportTree(change.Previous),
+				Diff:        mapFieldChanges(change.Diff),
+				Error:       change.Error,
+			}
+		}),
+	})
+}
+
+// importFilter reads the query parameters "from", "to", "user", "limit" and
+// "offset". Dates are either RFC 3339 timestamps or plain dates, a plain "to"
+// date includes the whole day.
+func importFilter(c *fiber.Ctx) (entities.ImportFilter, error) {
+	filter := entities.ImportFilter{
+		UserID: c.Query("user"),
+		Limit:  c.QueryInt("limit", importer.DefaultHistoryLimit),
+		Offset: c.QueryInt("offset", 0),
+	}
+
+	if from := c.Query("from"); from != "" {
+		parsed, _, err := parseDate(from)
+		if err != nil {
+			return filter, fiber.NewError(fiber.StatusBadRequest, "invalid value for from, expected a date like 2024-03-01")
+		}
+		filter.From = parsed
+	}
+
+	if to := c.Query("to"); to != "" {
+		parsed, dateOnly, err := parseDate(to)
+		if err != nil {
+			return filter, fiber.NewError(fiber.StatusBadRequest, "invalid value for to, expected a date like 2024-03-31")
+		}
+		if dateOnly {
+			parsed = parsed.AddDate(0, 0, 1)
+		}
+		filter.To = parsed
+	}
+
+	return filter, nil
+}
+
+func parseDate(value string) (time.Time, bool, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, false, nil
+	}
+
+	parsed, err := time.Parse(dateLayout, value)
+	return parsed, true, err
+}
+
+func mapImportHistoryEntry(summary entities.ImportSummary) ImportHistoryEntryResponse {
+	return ImportHistoryEntryResponse{
+		ID:               summary.ID,
+		UserID:           summary.UserID,
+		Status:           summary.Status,
+		CreatedAt:        summary.CreatedAt,
+		FinishedAt:       summary.FinishedAt,
+		DurationMillis:   summary.Duration().Milliseconds(),
+		Error:            summary.Error,
+		SourceEPSG:       summary.SourceEPSG,
+		DetectedEPSG:     summary.DetectedEPSG,
+		Transformation:   summary.Transformation,
+		Accuracy:         summary.TransformationAccuracy,
+		CreatedCount:     summary.Created,
+		UpdatedCount:     summary.Updated,
+		DeletedCount:     summary.Deleted,
+		FailedCount:      summary.Failed,
+		CompensatedCount: summary.Compensated,
+	}
+}
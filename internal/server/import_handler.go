@@ -0,0 +1,695 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"iter"
+	"mime/multipart"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/entities"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/importer"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxUploadSize   = 64 * 1024 * 1024
+	uploadFormField = "file"
+)
+
+type ImportTreeResponse struct {
+	ID           entities.TreeID           `json:"id"`
+	BackendID    *entities.TreeBackendID   `json:"backend_id"`
+	Area         entities.TreeArea         `json:"area"`
+	Number       entities.TreeNumber       `json:"tree_number"`
+	Species      entities.TreeSpecies      `json:"species"`
+	Latitude     entities.TreeLatitude     `json:"latitude"`
+	Longitude    entities.TreeLongitude    `json:"longitude"`
+	PlantingYear entities.TreePlantingYear `json:"planting_year"`
+	Street       entities.TreeStreet       `json:"street"`
+}
+
+type CSVDialectResponse struct {
+	Encoding  string `json:"encoding"`
+	HasBOM    bool   `json:"bom"`
+	Delimiter string `json:"delimiter"`
+}
+
+type CRSScoreResponse struct {
+	EPSG   int `json:"epsg"`
+	Inside int `json:"inside"`
+	Total  int `json:"total"`
+}
+
+type CRSDetectionResponse struct {
+	Mode       string             `json:"mode"`
+	Configured int                `json:"configured_epsg"`
+	Detected   int                `json:"detected_epsg"`
+	Used       int                `json:"used_epsg"`
+	Scores     []CRSScoreResponse `json:"scores"`
+}
+
+type TransformationResponse struct {
+	FromEPSG int      `json:"from_epsg"`
+	ToEPSG   int      `json:"to_epsg"`
+	Backend  string   `json:"backend"`
+	Pipeline string   `json:"pipeline"`
+	GridFile string   `json:"grid_file,omitempty"`
+	Accuracy *float64 `json:"accuracy_m"`
+	Assumed  bool     `json:"assumed"`
+}
+
+type CRSMismatchResponse struct {
+	Error      string             `json:"error"`
+	Configured int                `json:"configured_epsg"`
+	Detected   int                `json:"detected_epsg"`
+	Scores     []CRSScoreResponse `json:"scores"`
+}
+
+type ImportSummaryResponse struct {
+	ID             entities.ImportID       `json:"id"`
+	Status         entities.ImportStatus   `json:"status"`
+	Mode           string                  `json:"mode"`
+	CreatedCount   int                     `json:"created_count"`
+	UpdatedCount   int                     `json:"updated_count"`
+	DeletedCount   int                     `json:"deleted_count"`
+	UnlinkedCount  int                     `json:"unlinked_count"`
+	UnchangedCount int                     `json:"unchanged_count"`
+	Created        []ImportTreeResponse    `json:"created"`
+	Updated        []ImportTreeResponse    `json:"updated"`
+	Deleted        []entities.TreeID       `json:"deleted"`
+	Unlinked       []ImportTreeResponse    `json:"unlinked"`
+	RowErrors      []RowErrorResponse      `json:"row_errors"`
+	Dialect        CSVDialectResponse      `json:"dialect"`
+	CRS            *CRSDetectionResponse   `json:"crs"`
+	Transformation *TransformationResponse `json:"transformation"`
+	Errors         []TreeErrorResponse     `json:"errors"`
+	Stats          ImportStatsResponse     `json:"stats"`
+	DuplicateOf    *entities.ImportID      `json:"duplicate_of"`
+}
+
+type TreeConflictResponse struct {
+	TreeID   entities.TreeID   `json:"tree_id"`
+	ImportID entities.ImportID `json:"import_id"`
+}
+
+type UndoConflictResponse struct {
+	Error     string                 `json:"error"`
+	Conflicts []TreeConflictResponse `json:"conflicts"`
+}
+
+type DuplicateImportResponse struct {
+	Error       string            `json:"error"`
+	DuplicateOf entities.ImportID `json:"duplicate_of"`
+}
+
+type TreeErrorResponse struct {
+	OperationID entities.ImportOperationID `json:"operation_id"`
+	Operation   entities.OperationType     `json:"operation"`
+	Tree        *ImportTreeResponse        `json:"tree"`
+	Error       string                     `json:"error"`
+}
+
+type ImportStatsResponse struct {
+	Operations     int     `json:"operations"`
+	Applied        int     `json:"applied"`
+	Failed         int     `json:"failed"`
+	DurationMillis int64   `json:"duration_ms"`
+	PerSecond      float64 `json:"operations_per_second"`
+}
+
+type MatchCandidateResponse struct {
+	ID       entities.TreeID `json:"id"`
+	Distance float64         `json:"distance"`
+}
+
+type AmbiguousMatchResponse struct {
+	Tree       ImportTreeResponse       `json:"tree"`
+	Candidates []MatchCandidateResponse `json:"candidates"`
+	ChosenID   *entities.TreeID         `json:"chosen_id"`
+}
+
+type PlannedChangeResponse struct {
+	Action   string                `json:"action"`
+	Reason   string                `json:"reason"`
+	Distance float64               `json:"distance"`
+	Old      *ImportTreeResponse   `json:"old"`
+	New      *ImportTreeResponse   `json:"new"`
+	Diff     []FieldChangeResponse `json:"diff"`
+}
+
+type FieldChangeResponse struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type ImportPlanResponse struct {
+	Mode                 string                   `json:"mode"`
+	RequiresConfirmation bool                     `json:"requires_confirmation"`
+	CreateCount          int                      `json:"create_count"`
+	UpdateCount          int                      `json:"update_count"`
+	DeleteCount          int                      `json:"delete_count"`
+	UnchangedCount       int                      `json:"unchanged_count"`
+	UnlinkedCount        int                      `json:"unlinked_count"`
+	Changes              []PlannedChangeResponse  `json:"changes"`
+	Unlinked             []ImportTreeResponse     `json:"unlinked"`
+	Ambiguous            []AmbiguousMatchResponse `json:"ambiguous"`
+	RowErrors            []RowErrorResponse       `json:"row_errors"`
+	Dialect              CSVDialectResponse       `json:"dialect"`
+	CRS                  *CRSDetectionResponse    `json:"crs"`
+	Transformation       *TransformationResponse  `json:"transformation"`
+}
+
+type RowErrorResponse struct {
+	Row    int    `json:"row"`
+	Column string `json:"column"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+type ImportFailedResponse struct {
+	Error   string                `json:"error"`
+	Summary ImportSummaryResponse `json:"summary"`
+}
+
+type ImportStatusResponse struct {
+	ID     entities.ImportID     `json:"id"`
+	Status entities.ImportStatus `json:"status"`
+}
+
+type ErrorResponse struct {
+	Error     string             `json:"error"`
+	RowErrors []RowErrorResponse `json:"row_errors,omitempty"`
+}
+
+func errorHandler(c *fiber.Ctx, err error) error {
+	code := fiber.StatusInternalServerError
+
+	var validationErr *importer.ValidationError
+	if errors.As(err, &validationErr) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(ErrorResponse{
+			Error:     validationErr.Error(),
+			RowErrors: mapRowErrors(validationErr.Errors),
+		})
+	}
+
+	var incompleteErr *importer.IncompleteFileError
+	if errors.As(err, &incompleteErr) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(ErrorResponse{Error: incompleteErr.Error()})
+	}
+
+	var mismatchErr *importer.CRSMismatchError
+	if errors.As(err, &mismatchErr) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(CRSMismatchResponse{
+			Error:      mismatchErr.Error(),
+			Configured: mismatchErr.Configured,
+			Detected:   mismatchErr.Detected,
+			Scores:     mapCRSScores(mismatchErr.Scores),
+		})
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		code = fiberErr.Code
+	}
+
+	return c.Status(code).JSON(ErrorResponse{Error: err.Error()})
+}
+
+func (s *Server) createImport(c *fiber.Ctx) error {
+	if s.cfg.importService == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "import service is not available")
+	}
+
+	opts, err := importOptions(c)
+	if err != nil {
+		return err
+	}
+	if user := currentUser(c); user != nil {
+		opts.UserID = user.ID
+	}
+
+	source, err := s.openUpload(c)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	opts.File = source.spool
+
+	result, err := s.cfg.importService.Import(c.UserContext(), source, opts)
+	converted := source.Result()
+	if err != nil {
+		var deletionsErr *importer.TooManyDeletionsError
+		if errors.As(err, &deletionsErr) {
+			return fiber.NewError(fiber.StatusConflict, deletionsErr.Error())
+		}
+
+		var duplicateErr *importer.DuplicateImportError
+		if errors.As(err, &duplicateErr) {
+			return c.Status(fiber.StatusConflict).JSON(DuplicateImportResponse{
+				Error:       duplicateErr.Error(),
+				DuplicateOf: duplicateErr.ImportID,
+			})
+		}
+
+		var failedErr *importer.ImportFailedError
+		if errors.As(err, &failedErr) {
+			return c.Status(fiber.StatusBadGateway).JSON(ImportFailedResponse{
+				Error:   failedErr.Error(),
+				Summary: mapImportSummary(result, converted),
+			})
+		}
+		return errors.Wrap(err, "failed to import trees")
+	}
+
+	if result.DuplicateOf != nil {
+		// nothing was imported
+		return c.JSON(mapImportSummary(result, converted))
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(mapImportSummary(result, converted))
+}
+
+func (s *Server) previewImport(c *fiber.Ctx) error {
+	if s.cfg.importService == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "import service is not available")
+	}
+
+	opts, err := importOptions(c)
+	if err != nil {
+		return err
+	}
+
+	source, err := s.openUpload(c)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	plan, err := s.cfg.importService.Plan(c.UserContext(), source, opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to plan import")
+	}
+
+	return c.JSON(mapImportPlan(plan, source.Result()))
+}
+
+func (s *Server) compensateImport(c *fiber.Ctx) error {
+	if s.cfg.importService == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "import service is not available")
+	}
+
+	importID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid import id")
+	}
+
+	status, err := s.cfg.importService.Compensate(c.UserContext(), entities.ImportID(importID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "import not found")
+		}
+		if errors.Is(err, importer.ErrInvalidImportState) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		return err
+	}
+
+	return c.JSON(ImportStatusResponse{
+		ID:     entities.ImportID(importID),
+		Status: status,
+	})
+}
+
+func (s *Server) resumeImport(c *fiber.Ctx) error {
+	if s.cfg.importService == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "import service is not available")
+	}
+
+	importID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid import id")
+	}
+
+	// a resumed import replays its journal, there is no uploaded file to report on
+	converted := &importer.ConvertResult{}
+
+	result, err := s.cfg.importService.Resume(c.UserContext(), entities.ImportID(importID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "import not found")
+		}
+		if errors.Is(err, importer.ErrInvalidImportState) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+
+		var failedErr *importer.ImportFailedError
+		if errors.As(err, &failedErr) {
+			return c.Status(fiber.StatusBadGateway).JSON(ImportFailedResponse{
+				Error:   failedErr.Error(),
+				Summary: mapImportSummary(result, converted),
+			})
+		}
+		return errors.Wrap(err, "failed to resume import")
+	}
+
+	return c.JSON(mapImportSummary(result, converted))
+}
+
+func (s *Server) undoImport(c *fiber.Ctx) error {
+	if s.cfg.importService == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "import service is not available")
+	}
+
+	importID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid import id")
+	}
+
+	status, err := s.cfg.importService.Undo(c.UserContext(), entities.ImportID(importID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "import not found")
+		}
+		if errors.Is(err, importer.ErrInvalidImportState) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+
+		var conflictErr *importer.UndoConflictError
+		if errors.As(err, &conflictErr) {
+			return c.Status(fiber.StatusConflict).JSON(UndoConflictResponse{
+				Error: conflictErr.Error(),
+				Conflicts: utils.Map(conflictErr.Conflicts, func(conflict entities.TreeConflict) TreeConflictResponse {
+					return TreeConflictResponse{
+						TreeID:   conflict.TreeID,
+						ImportID: conflict.ImportID,
+					}
+				}),
+			})
+		}
+		return err
+	}
+
+	return c.JSON(ImportStatusResponse{
+		ID:     entities.ImportID(importID),
+		Status: status,
+	})
+}
+
+// downloadImportFile responds with the CSV file of an import exactly as it
+// was uploaded.
+func (s *Server) downloadImportFile(c *fiber.Ctx) error {
+	if s.cfg.importService == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "import service is not available")
+	}
+
+	importID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid import id")
+	}
+
+	file, err := s.cfg.importService.GetImportFile(c.UserContext(), entities.ImportID(importID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "no file stored for import")
+		}
+		return errors.Wrap(err, "failed to load import file")
+	}
+
+	c.Attachment(file.Filename)
+	c.Set(fiber.HeaderContentType, "text/csv; charset="+file.Encoding)
+	c.Set("X-Content-SHA256", file.SHA256)
+	return c.Send(file.Content)
+}
+
+// importOptions reads the sync mode, the deletion confirmation and whether a
+// repeated upload is forced from the form fields "mode", "confirm" and "force".
+func importOptions(c *fiber.Ctx) (importer.ImportOptions, error) {
+	var opts importer.ImportOptions
+
+	if mode := c.FormValue("mode"); mode != "" {
+		syncMode, err := importer.ParseSyncMode(mode)
+		if err != nil {
+			return opts, fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		opts.Mode = syncMode
+	}
+
+	if force := c.FormValue("force"); force != "" {
+		forced, err := strconv.ParseBool(force)
+		if err != nil {
+			return opts, fiber.NewError(fiber.StatusBadRequest, "invalid value for force, expected true or false")
+		}
+		opts.Force = forced
+	}
+
+	if confirm := c.FormValue("confirm"); confirm != "" {
+		confirmed, err := strconv.ParseBool(confirm)
+		if err != nil {
+			return opts, fiber.NewError(fiber.StatusBadRequest, "invalid value for confirm, expected true or false")
+		}
+		opts.ConfirmDeletions = confirmed
+	}
+
+	return opts, nil
+}
+
+// uploadSource streams the trees of an uploaded CSV file. The file is recorded
+// while it is read, so it can be stored with the import.
+type uploadSource struct {
+	*importer.CSVConverter
+	upload multipart.File
+	spool  *importer.ImportFileSpool
+}
+
+// openUpload opens the uploaded CSV file for reading its trees. The upload has
+// to be closed once the trees were read.
+func (s *Server) openUpload(c *fiber.Ctx) (*uploadSource, error) {
+	fileHeader, err := c.FormFile(uploadFormField)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "missing csv file in form field '"+uploadFormField+"'")
+	}
+
+	if strings.ToLower(filepath.Ext(fileHeader.Filename)) != ".csv" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "file is not a CSV file")
+	}
+
+	if s.cfg.transformers == nil {
+		return nil, fiber.NewError(fiber.StatusServiceUnavailable, "CSV converter is not available")
+	}
+
+	upload, err := fileHeader.Open()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open uploaded file")
+	}
+
+	spool := importer.NewImportFileSpool(fileHeader.Filename)
+	return &uploadSource{
+		CSVConverter: importer.NewCSVConverter(s.cfg.converterCfg, s.cfg.transformers, spool.Reader(upload)),
+		upload:       upload,
+		spool:        spool,
+	}, nil
+}
+
+// Batches reports files that cannot be converted as unprocessable.
+func (u *uploadSource) Batches(ctx context.Context) iter.Seq2[[]*entities.Tree, error] {
+	return func(yield func([]*entities.Tree, error) bool) {
+		for batch, err := range u.CSVConverter.Batches(ctx) {
+			if err != nil {
+				var validationErr *importer.ValidationError
+				var mismatchErr *importer.CRSMismatchError
+				if !errors.As(err, &validationErr) && !errors.As(err, &mismatchErr) && ctx.Err() == nil {
+					err = fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+				}
+			}
+			if !yield(batch, err) {
+				return
+			}
+		}
+	}
+}
+
+func (u *uploadSource) Close() error {
+	return u.upload.Close()
+}
+
+func mapImportSummary(result *importer.ImportResult, converted *importer.ConvertResult) ImportSummaryResponse {
+	return ImportSummaryResponse{
+		ID:             result.ImportID,
+		Status:         result.Status,
+		Mode:           string(result.Mode),
+		CreatedCount:   len(result.Created),
+		UpdatedCount:   len(result.Updated),
+		DeletedCount:   len(result.Deleted),
+		UnlinkedCount:  len(result.Unlinked),
+		UnchangedCount: len(result.Unchanged),
+		Created:        mapImportTrees(result.Created),
+		Updated:        mapImportTrees(result.Updated),
+		Deleted:        result.Deleted,
+		Unlinked:       mapImportTrees(result.Unlinked),
+		RowErrors:      mapRowErrors(converted.Errors),
+		Dialect:        mapCSVDialect(converted.Dialect),
+		CRS:            mapCRSDetection(converted.CRS),
+		Transformation: mapTransformation(converted.Transformation),
+		Errors: utils.Map(result.Errors, func(treeErr *importer.TreeError) TreeErrorResponse {
+			return TreeErrorResponse{
+				OperationID: treeErr.OperationID,
+				Operation:   treeErr.Operation,
+				Tree:        mapOptionalImportTree(treeErr.Tree),
+				Error:       treeErr.Err.Error(),
+			}
+		}),
+		Stats: ImportStatsResponse{
+			Operations:     result.Stats.Operations,
+			Applied:        result.Stats.Applied,
+			Failed:         result.Stats.Failed,
+			DurationMillis: result.Stats.Duration.Milliseconds(),
+			PerSecond:      result.Stats.Throughput(),
+		},
+		DuplicateOf: result.DuplicateOf,
+	}
+}
+
+func mapImportPlan(plan *importer.ImportPlan, converted *importer.ConvertResult) ImportPlanResponse {
+	return ImportPlanResponse{
+		Mode:                 string(plan.Mode),
+		RequiresConfirmation: plan.RequiresConfirmation,
+		CreateCount:          len(plan.Creates()),
+		UpdateCount:          len(plan.Updates()),
+		DeleteCount:          len(plan.Deletes()),
+		UnchangedCount:       len(plan.Unchanged),
+		UnlinkedCount:        len(plan.Unlinked),
+		Changes: utils.Map(plan.Changes, func(change importer.PlannedChange) PlannedChangeResponse {
+			return PlannedChangeResponse{
+				Action:   string(change.Action),
+				Reason:   change.Reason,
+				Distance: change.Distance,
+				Old:      mapOptionalImportTree(change.Existing),
+				New:      mapOptionalImportTree(change.Tree),
+				Diff:     mapFieldChanges(change.Diff),
+			}
+		}),
+		Unlinked:       mapImportTrees(plan.Unlinked),
+		Ambiguous:      mapAmbiguousMatches(plan.Ambiguous),
+		RowErrors:      mapRowErrors(converted.Errors),
+		Dialect:        mapCSVDialect(converted.Dialect),
+		CRS:            mapCRSDetection(converted.CRS),
+		Transformation: mapTransformation(converted.Transformation),
+	}
+}
+
+func mapTransformation(transformation *importer.Transformation) *TransformationResponse {
+	if transformation == nil {
+		return nil
+	}
+	return &TransformationResponse{
+		FromEPSG: transformation.From,
+		ToEPSG:   transformation.To,
+		Backend:  string(transformation.Backend),
+		Pipeline: transformation.Pipeline,
+		GridFile: transformation.GridFile,
+		Accuracy: transformation.Accuracy,
+		Assumed:  transformation.Assumed,
+	}
+}
+
+func mapCRSDetection(crs *importer.CRSDetection) *CRSDetectionResponse {
+	if crs == nil {
+		return nil
+	}
+	return &CRSDetectionResponse{
+		Mode:       string(crs.Mode),
+		Configured: crs.Configured,
+		Detected:   crs.Detected,
+		Used:       crs.Used,
+		Scores:     mapCRSScores(crs.Scores),
+	}
+}
+
+func mapCRSScores(scores []importer.CRSScore) []CRSScoreResponse {
+	return utils.Map(scores, func(score importer.CRSScore) CRSScoreResponse {
+		return CRSScoreResponse{
+			EPSG:   score.EPSG,
+			Inside: score.Inside,
+			Total:  score.Total,
+		}
+	})
+}
+
+func mapFieldChanges(changes []entities.FieldChange) []FieldChangeResponse {
+	return utils.Map(changes, func(change entities.FieldChange) FieldChangeResponse {
+		return FieldChangeResponse{
+			Field: change.Field,
+			Old:   change.Old,
+			New:   change.New,
+		}
+	})
+}
+
+func mapAmbiguousMatches(ambiguous []importer.AmbiguousMatch) []AmbiguousMatchResponse {
+	return utils.Map(ambiguous, func(match importer.AmbiguousMatch) AmbiguousMatchResponse {
+		resp := AmbiguousMatchResponse{
+			Tree: mapImportTree(match.Tree),
+			Candidates: utils.Map(match.Candidates, func(candidate importer.TreeMatch) MatchCandidateResponse {
+				return MatchCandidateResponse{
+					ID:       candidate.Existing.TreeID,
+					Distance: candidate.Distance,
+				}
+			}),
+		}
+		if match.Chosen != nil {
+			resp.ChosenID = &match.Chosen.TreeID
+		}
+		return resp
+	})
+}
+
+func mapCSVDialect(dialect importer.CSVDialect) CSVDialectResponse {
+	return CSVDialectResponse{
+		Encoding:  dialect.Encoding,
+		HasBOM:    dialect.HasBOM,
+		Delimiter: string(dialect.Delimiter),
+	}
+}
+
+func mapRowErrors(rowErrors []*importer.RowError) []RowErrorResponse {
+	return utils.Map(rowErrors, func(rowErr *importer.RowError) RowErrorResponse {
+		return RowErrorResponse{
+			Row:    rowErr.Row,
+			Column: rowErr.Column,
+			Value:  rowErr.Value,
+			Reason: rowErr.Reason,
+		}
+	})
+}
+
+func mapImportTrees(trees []*entities.Tree) []ImportTreeResponse {
+	return utils.Map(trees, mapImportTree)
+}
+
+func mapOptionalImportTree(tree *entities.Tree) *ImportTreeResponse {
+	if tree == nil {
+		return nil
+	}
+	resp := mapImportTree(tree)
+	return &resp
+}
+
+func mapImportTree(tree *entities.Tree) ImportTreeResponse {
+	return ImportTreeResponse{
+		ID:           tree.TreeID,
+		BackendID:    tree.BackendID,
+		Area:         tree.Area,
+		Number:       tree.Number,
+		Species:      tree.Species,
+		Latitude:     tree.Latitude,
+		Longitude:    tree.Longitude,
+		PlantingYear: tree.PlantingYear,
+		Street:       tree.Street,
+	}
+}
@@ -7,13 +7,20 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/green-ecolution/green-ecolution-backend/plugin"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/auth"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/importer"
 )
 
 type ServerConfig struct {
-	port     int
-	plugin   plugin.Plugin
-	pluginFS embed.FS
-	version  string
+	port          int
+	plugin        plugin.Plugin
+	pluginFS      embed.FS
+	version       string
+	importService *importer.ImportService
+	converterCfg  importer.ConverterConfig
+	transformers  *importer.GeoTransformers
+	tokenSource   *auth.TokenSource
+	verifier      *auth.Verifier
 }
 
 type Server struct {
@@ -46,9 +53,37 @@ func WithPlugin(plugin plugin.Plugin) ServerOption {
 	}
 }
 
+func WithImportService(importService *importer.ImportService) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.importService = importService
+	}
+}
+
+// WithConverter sets the validated converter configuration and the
+// transformers created from it.
+func WithConverter(converterCfg importer.ConverterConfig, transformers *importer.GeoTransformers) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.converterCfg = converterCfg
+		cfg.transformers = transformers
+	}
+}
+
+func WithTokenSource(tokenSource *auth.TokenSource) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.tokenSource = tokenSource
+	}
+}
+
+// WithVerifier enables the verification of the bearer tokens of API requests.
+func WithVerifier(verifier *auth.Verifier) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.verifier = verifier
+	}
+}
+
 var defaultServerConfig = &ServerConfig{
-	port: 8080,
-  version: "develop",
+	port:    8080,
+	version: "develop",
 }
 
 func NewServer(opts ...ServerOption) *Server {
@@ -63,11 +98,27 @@ func NewServer(opts ...ServerOption) *Server {
 
 func (s *Server) Run(ctx context.Context) error {
 	app := fiber.New(fiber.Config{
-    AppName: fmt.Sprintf("%s (%s)", s.cfg.plugin.Name, s.cfg.version),
-  })
+		AppName:      fmt.Sprintf("%s (%s)", s.cfg.plugin.Name, s.cfg.version),
+		BodyLimit:    maxUploadSize,
+		ErrorHandler: errorHandler,
+	})
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("Hello, World! This is the plugin server for " + s.cfg.plugin.Name)
 	})
+
+	api := app.Group("/api/v1")
+	api.Get("/health", s.health)
+
+	api.Use(s.authenticate)
+	api.Get("/imports", s.listImports)
+	api.Post("/imports", s.requireImportRole, s.createImport)
+	api.Get("/imports/:id", s.getImport)
+	api.Get("/imports/:id/file", s.downloadImportFile)
+	api.Post("/imports/preview", s.previewImport)
+	api.Post("/imports/:id/compensate", s.requireImportRole, s.compensateImport)
+	api.Post("/imports/:id/resume", s.requireImportRole, s.resumeImport)
+	api.Post("/imports/:id/undo", s.requireImportRole, s.undoImport)
+
 	app.Mount("/", servePlugin(s.cfg.pluginFS))
 
 	go func() {
@@ -1,11 +1,11 @@
 package internal
 
 import (
-  "github.com/green-ecolution/green-ecolution-backend/client"
+	"github.com/green-ecolution/green-ecolution-backend/client"
 )
 
 type GreenEcolutionRepo interface {
-  GetInfo() client.ApiGetAppInfoRequest
+	GetInfo() client.ApiGetAppInfoRequest
 }
 
 type CsvRepo interface {
@@ -11,9 +11,12 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/green-ecolution/green-ecolution-backend/client"
 	"github.com/green-ecolution/green-ecolution-backend/plugin"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/auth"
+	"github.com/green-ecolution/tbz-csv-import-plugin/internal/importer"
 	"github.com/green-ecolution/tbz-csv-import-plugin/internal/importer/storage"
 	"github.com/green-ecolution/tbz-csv-import-plugin/internal/server"
 	"github.com/jmoiron/sqlx"
@@ -37,6 +40,21 @@ func main() {
 	clientSecret := os.Getenv("CLIENT_SECRET")
 	hostPathEnv := os.Getenv("HOST_PATH")
 
+	converterCfg, err := importer.LoadConverterConfig()
+	if err != nil {
+		log.Fatalf("Invalid CSV converter configuration: %v", err)
+	}
+
+	transformers, err := importer.NewGeoTransformers(converterCfg)
+	if err != nil {
+		log.Fatalf("Invalid CSV converter configuration: %v", err)
+	}
+
+	importCfg, err := importer.LoadImportConfig()
+	if err != nil {
+		log.Fatalf("Invalid import configuration: %v", err)
+	}
+
 	pluginPath, err := url.Parse("http://localhost:8123/")
 	if err != nil {
 		panic(err)
@@ -50,27 +68,9 @@ func main() {
 		PluginHostPath: pluginPath,
 	}
 
-	http := server.NewServer(
-		server.WithPort(8123),
-		server.WithPluginFS(f),
-		server.WithPlugin(p),
-		server.WithVersion(version),
-	)
-
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	var wg sync.WaitGroup
-	//wg.Add(2)
-	wg.Add(1)
-
-	go func() {
-		defer wg.Done()
-		if err = http.Run(ctx); err != nil {
-			slog.Error("Error while running http server", "error", err)
-		}
-	}()
-
 	db := sqlx.MustConnect("sqlite3", "file:import.db?cache=shared")
 	importRepo := storage.NewImportRepositoryDB(db)
 
@@ -93,19 +93,29 @@ func main() {
 		panic(err)
 	}
 
-	token, err := worker.Register(ctx, clientID, clientSecret)
+	// the backend hands out new tokens on registration, registering again also
+	// renews the token the worker uses for the heartbeat
+	register := func(ctx context.Context) (*oauth2.Token, error) {
+		token, err := worker.Register(ctx, clientID, clientSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		return &oauth2.Token{
+			AccessToken:  token.AccessToken,
+			RefreshToken: token.RefreshToken,
+			Expiry:       token.Expiry,
+			ExpiresIn:    token.ExpiresIn,
+			TokenType:    "Bearer",
+		}, nil
+	}
+
+	oauthToken, err := register(ctx)
 	if err != nil {
 		panic(err)
 	}
 
-	oauthToken := &oauth2.Token{
-		AccessToken:  token.AccessToken,
-		RefreshToken: token.RefreshToken,
-		Expiry:       token.Expiry,
-		ExpiresIn:    token.ExpiresIn,
-		TokenType:    "Bearer",
-	}
-	oauthClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(oauthToken))
+	tokenSource := auth.NewTokenSource(ctx, oauthToken, register)
 	clientCfg := client.NewConfiguration()
 	clientCfg.Servers = client.ServerConfigurations{
 		{
@@ -114,23 +124,81 @@ func main() {
 		},
 	}
 	clientCfg.Debug = true
-	clientCfg.HTTPClient = oauthClient
+	clientCfg.HTTPClient = auth.NewHTTPClient(tokenSource)
 
-	repo := storage.NewGreenEcolutionRepo(clientCfg)
+	repo := storage.NewGreenEcolutionRepo(clientCfg, importCfg.Retry)
 
-	auth := context.WithValue(ctx, client.ContextOAuth2, oauthToken)
-	info, err := repo.GetInfo(auth)
+	info, err := repo.GetInfo(ctx)
 	if err != nil {
 		slog.Error("Error while getting app info", "error", err)
 	}
 	slog.Info("App info", "info", info)
 
+	importService := importer.NewImportService(importRepo, repo, importCfg)
+
+	verifierCfg, err := auth.LoadVerifierConfig()
+	if err != nil {
+		log.Fatalf("Invalid token verification configuration: %v", err)
+	}
+
+	var verifier *auth.Verifier
+	if verifierCfg.Disabled {
+		slog.Warn("AUTH_DISABLED is set, requests are not authenticated and imports are recorded without a user")
+	} else if verifier, err = auth.NewVerifier(verifierCfg); err != nil {
+		log.Fatalf("Invalid token verification configuration: %v", err)
+	}
+
+	http := server.NewServer(
+		server.WithPort(8123),
+		server.WithPluginFS(f),
+		server.WithPlugin(p),
+		server.WithVersion(version),
+		server.WithImportService(importService),
+		server.WithConverter(converterCfg, transformers),
+		server.WithTokenSource(tokenSource),
+		server.WithVerifier(verifier),
+	)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
 	go func() {
 		defer wg.Done()
-		if err := worker.RunHeartbeat(ctx); err != nil {
-			slog.Error("Failed to send heartbeat", "error", err)
+		if err = http.Run(ctx); err != nil {
+			slog.Error("Error while running http server", "error", err)
 		}
 	}()
 
+	go func() {
+		defer wg.Done()
+		runHeartbeat(ctx, worker, tokenSource)
+	}()
+
 	wg.Wait()
 }
+
+const heartbeatRestartDelay = 10 * time.Second
+
+// runHeartbeat keeps the heartbeat running until ctx is done. An expired token
+// is the usual reason for the heartbeat to stop, so the token is renewed before
+// the heartbeat is restarted.
+func runHeartbeat(ctx context.Context, worker *plugin.PluginWorker, tokenSource *auth.TokenSource) {
+	for {
+		err := worker.RunHeartbeat(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("Failed to send heartbeat", "error", err)
+
+		tokenSource.Invalidate()
+		if _, err := tokenSource.Token(); err != nil {
+			slog.Error("Heartbeat is stopped until the access token can be renewed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(heartbeatRestartDelay):
+		}
+	}
+}